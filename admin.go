@@ -0,0 +1,364 @@
+package busybody
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// the number of entries buffered for a slow /monitor client before dropping
+const monitorBufferSize = 256
+
+// how long POST /leave waits for the member to shut down
+const adminLeaveTimeout = 10 * time.Second
+
+// AdminHandler returns an http.Handler exposing this member's view of the
+// cluster as JSON, for debugging a running member. Mount it behind
+// http.StripPrefix to serve it below a path.
+//
+//	GET  /self         identity of this member
+//	GET  /members      member list with state and connection status
+//	GET  /events       recent membership changes, oldest first
+//	GET  /queues       queue depths and counters
+//	GET  /config       configuration, with the shared key redacted
+//	GET  /monitor      stream of MonitorEntry, one JSON document per line
+//	POST /join         {"seeds": ["tcp://..."]}
+//	POST /leave        leave the cluster and shut the member down
+//	POST /force-leave  {"id": "..."}
+//	POST /reload       {"swim_interval": "30s", ...} applied over the current config
+//	POST /send         {"payload": "...", "topic": "...", "ttl": "10s"}
+//	POST /event        {"name": "...", "payload": "...", "coalesce": false}
+//
+// Members carry no incarnation numbers or tags on the wire, so /members does
+// not report them; adding them needs a change to the introduction format and
+// is out of scope here.
+//
+// The handler has no authentication of its own; only expose it to trusted
+// networks.
+func (m *BusyMember) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/self", m.adminGet(m.adminSelf))
+	mux.HandleFunc("/members", m.adminGet(m.adminMembers))
+	mux.HandleFunc("/events", m.adminGet(func() interface{} { return m.MembershipEvents() }))
+	mux.HandleFunc("/queues", m.adminGet(m.adminQueues))
+	mux.HandleFunc("/config", m.adminGet(m.adminConfig))
+	mux.HandleFunc("/join", m.adminPost(m.adminJoin))
+	mux.HandleFunc("/leave", m.adminPost(m.adminLeave))
+	mux.HandleFunc("/force-leave", m.adminPost(m.adminForceLeave))
+	mux.HandleFunc("/reload", m.adminPost(m.adminReload))
+	mux.HandleFunc("/send", m.adminPost(m.adminSend))
+	mux.HandleFunc("/event", m.adminPost(m.adminEvent))
+	mux.HandleFunc("/monitor", m.adminMonitor)
+
+	return mux
+}
+
+// adminMember is the JSON form of a member. There is no incarnation or tags
+// field since introductions carry neither.
+type adminMember struct {
+	Id        string    `json:"id"`
+	Uri       string    `json:"uri"`
+	State     string    `json:"state"`
+	Connected bool      `json:"connected"`
+	Changed   time.Time `json:"changed"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (m *BusyMember) adminGet(get func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires GET", r.URL.Path))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, get())
+	}
+}
+
+func (m *BusyMember) adminPost(post func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires POST", r.URL.Path))
+			return
+		}
+
+		v, err := post(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// decodeBody decodes an optional JSON request body into v
+func decodeBody(r *http.Request, v interface{}) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding request: %v", err)
+	}
+
+	return nil
+}
+
+func (m *BusyMember) adminSelf() interface{} {
+	m.lock.RLock()
+	listening := m.listening
+	m.lock.RUnlock()
+
+	return map[string]interface{}{
+		"id":        m.id,
+		"uri":       m.Uri(),
+		"hostname":  m.hostname,
+		"listening": listening,
+		"stopped":   m.stopped(),
+	}
+}
+
+func (m *BusyMember) adminMembers() interface{} {
+	peers := m.Members()
+	out := make([]adminMember, 0, len(peers))
+
+	for _, p := range peers {
+		out = append(out, adminMember{
+			Id:        p.Id,
+			Uri:       p.Uri,
+			State:     stateName(p.state),
+			Connected: p.connected,
+			Changed:   p.changed,
+		})
+	}
+
+	return out
+}
+
+func (m *BusyMember) adminQueues() interface{} {
+	m.lock.RLock()
+	handlers := make([]int, 0)
+	for _, r := range m.handlers {
+		if r.queue != nil {
+			handlers = append(handlers, r.queue.len())
+		}
+	}
+	m.lock.RUnlock()
+
+	causal := 0
+	if m.causal != nil {
+		causal = m.causal.len()
+	}
+
+	stats := m.Stats()
+
+	return map[string]interface{}{
+		"dispatch":                 stats.QueuedMessages,
+		"handlers":                 handlers,
+		"incoming":                 len(m.incomingMessages),
+		"incoming_control":         len(m.controlMessages),
+		"outgoing":                 len(m.outgoingMessages),
+		"outgoing_control":         len(m.controlOutgoing),
+		"causal_pending":           causal,
+		"reassembly_bytes":         m.reassembler.Pending(),
+		"expired_messages":         stats.ExpiredMessages,
+		"dropped_messages":         stats.DroppedMessages,
+		"dropped_handler_messages": stats.DroppedHandlerMessages,
+		"dropped_received":         stats.DroppedReceived,
+	}
+}
+
+// adminConfig returns the settings keyed by TOML key, with secrets redacted
+func (m *BusyMember) adminConfig() interface{} {
+	return redactedConfig(m.conf())
+}
+
+func redactedConfig(conf *BusyConfig) map[string]interface{} {
+	v := reflect.ValueOf(conf).Elem()
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("toml")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		out[key] = v.Field(i).Interface()
+	}
+
+	if conf.SharedKey != "" {
+		out["shared_key"] = "REDACTED"
+	}
+
+	return out
+}
+
+func (m *BusyMember) adminJoin(r *http.Request) (interface{}, error) {
+	var req struct {
+		Seeds []string `json:"seeds"`
+	}
+
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	reached, err := m.Join(r.Context(), req.Seeds...)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]int{"reached": reached}, nil
+}
+
+func (m *BusyMember) adminLeave(r *http.Request) (interface{}, error) {
+	// the request is cancelled if the client goes away, which must not cut
+	// the leave short
+	ctx, cancel := context.WithTimeout(context.Background(), adminLeaveTimeout)
+	defer cancel()
+
+	if err := m.Shutdown(ctx); err != nil {
+		return nil, err
+	}
+
+	return map[string]bool{"left": true}, nil
+}
+
+func (m *BusyMember) adminForceLeave(r *http.Request) (interface{}, error) {
+	var req struct {
+		Id string `json:"id"`
+	}
+
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	if err := m.ForceLeave(req.Id); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"left": req.Id}, nil
+}
+
+// adminReload applies settings keyed by TOML key over a copy of the current
+// configuration
+func (m *BusyMember) adminReload(r *http.Request) (interface{}, error) {
+	settings := make(map[string]string)
+
+	if err := decodeBody(r, &settings); err != nil {
+		return nil, err
+	}
+
+	conf := *m.conf()
+	conf.Peers = append([]string(nil), conf.Peers...)
+
+	for key, value := range settings {
+		if err := conf.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Reload(&conf); err != nil {
+		return nil, err
+	}
+
+	return redactedConfig(m.conf()), nil
+}
+
+func (m *BusyMember) adminSend(r *http.Request) (interface{}, error) {
+	var req struct {
+		Payload string `json:"payload"`
+		Topic   string `json:"topic"`
+		TTL     string `json:"ttl"`
+	}
+
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	opts := make([]SendOption, 0, 2)
+
+	if req.Topic != "" {
+		opts = append(opts, WithTopic(req.Topic))
+	}
+
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %v", err)
+		}
+
+		opts = append(opts, WithTTL(ttl))
+	}
+
+	if err := m.Send([]byte(req.Payload), opts...); err != nil {
+		return nil, err
+	}
+
+	return map[string]int{"bytes": len(req.Payload)}, nil
+}
+
+func (m *BusyMember) adminEvent(r *http.Request) (interface{}, error) {
+	var req struct {
+		Name     string `json:"name"`
+		Payload  string `json:"payload"`
+		Coalesce bool   `json:"coalesce"`
+	}
+
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("event name required")
+	}
+
+	if err := m.UserEvent(req.Name, []byte(req.Payload), req.Coalesce); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"event": req.Name}, nil
+}
+
+// adminMonitor streams monitor entries until the client goes away or the
+// member is closed
+func (m *BusyMember) adminMonitor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires GET", r.URL.Path))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+
+	for entry := range m.Monitor(r.Context(), monitorBufferSize) {
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,125 @@
+package busybody
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminHandler(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	if err := member.RemovePeer("ipc:///tmp/ipc4.ipc"); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := member.AdminHandler()
+
+	get := func(path string, v interface{}) {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	var members []adminMember
+	get("/members", &members)
+
+	if len(members) != 3 || members[0].State != "healthy" {
+		t.Errorf("expected 3 healthy members, got %#v", members)
+	}
+
+	var events []MembershipEvent
+	get("/events", &events)
+
+	if len(events) != 1 || events[0].Kind != MemberRemoved {
+		t.Errorf("expected the removal to be recorded, got %#v", events)
+	}
+
+	var conf map[string]interface{}
+	get("/config", &conf)
+
+	if conf["shared_key"] != "REDACTED" {
+		t.Errorf("expected the shared key to be redacted, got %v", conf["shared_key"])
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("POST", "/reload", strings.NewReader(`{"swim_interval": "45s"}`)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /reload: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if member.conf().SwimInterval != 45*time.Second {
+		t.Errorf("expected the reloaded swim interval, got %s", member.conf().SwimInterval)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/force-leave", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET /force-leave to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestMembershipLog(t *testing.T) {
+	l := newMembershipLog(3)
+
+	for _, kind := range []string{MemberJoined, MemberSuspected, MemberFailed, MemberReaped} {
+		l.add(MembershipEvent{Kind: kind})
+	}
+
+	events := l.list()
+	if len(events) != 3 || events[0].Kind != MemberSuspected || events[2].Kind != MemberReaped {
+		t.Errorf("expected the 3 most recent events oldest first, got %#v", events)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entries := member.Monitor(ctx, 8)
+
+	if err := member.RemovePeer("ipc:///tmp/ipc4.ipc"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-entries:
+		if e.Kind != MonitorKindMember || e.Member.Kind != MemberRemoved {
+			t.Errorf("expected the removal, got %#v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a monitor entry")
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-entries:
+		if ok {
+			t.Errorf("expected the monitor to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the monitor to be closed once ctx is done")
+	}
+}
@@ -1,9 +1,9 @@
 package busybody
 
 import (
+	"fmt"
 	"os"
-
-	"github.com/zerklabs/auburn/log"
+	"time"
 )
 
 var (
@@ -14,6 +14,7 @@ const (
 	HealthyState int = iota
 	SuspiciousState
 	FaultyState
+	LeftState
 )
 
 type Introduction struct {
@@ -22,12 +23,37 @@ type Introduction struct {
 	Uri       string
 	connected bool
 	state     int
+	changed   time.Time
+}
+
+// setState moves the member to state, recording now as when it changed
+func (i *Introduction) setState(state int, now time.Time) {
+	if i.state != state {
+		i.state = state
+		i.changed = now
+	}
+}
+
+// Changed returns when the state of the member last changed
+func (i Introduction) Changed() time.Time {
+	return i.changed
+}
+
+// Connected reports whether there is currently a connection to the member
+func (i Introduction) Connected() bool {
+	return i.connected
+}
+
+// State returns the health of the member, one of HealthyState,
+// SuspiciousState, FaultyState or LeftState
+func (i Introduction) State() int {
+	return i.state
 }
 
 func init() {
 	h, err := os.Hostname()
 	if err != nil {
-		log.Errorf("error fetching hostname: %s", err)
+		fmt.Fprintf(os.Stderr, "error fetching hostname: %s\n", err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,213 @@
+package busybody
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// the maximum number of messages held back waiting for their causal
+// predecessors before the oldest are dropped
+const maxCausalPending = 1024
+
+// how long a message is held back before the predecessors it is waiting for
+// are given up on and skipped, and how often that is checked
+const (
+	causalPendingTimeout = 30 * time.Second
+	causalCheckInterval  = time.Second
+)
+
+// causalQueue holds back StandardMessages until every message which
+// causally precedes them has been delivered
+type causalQueue struct {
+	lock     sync.Mutex
+	sendLock sync.Mutex
+	id       string
+	clock    protocol.VectorClock
+	pending  []*pendingMessage
+	resync   map[string]bool
+	log      Logger
+}
+
+// pendingMessage is a message held back and the time it arrived
+type pendingMessage struct {
+	msg      *protocol.Message
+	received time.Time
+}
+
+func newCausalQueue(id string, log Logger) *causalQueue {
+	return &causalQueue{
+		id:      id,
+		log:     log,
+		clock:   make(protocol.VectorClock),
+		pending: make([]*pendingMessage, 0),
+		resync:  make(map[string]bool),
+	}
+}
+
+// send stamps msg with this members clock and calls send. Sends are made one
+// at a time so the stamp can be rolled back if send fails, rather than leave
+// a gap which holds back every later message until the pending timeout.
+func (q *causalQueue) send(msg *protocol.Message, send func() error) error {
+	q.sendLock.Lock()
+	defer q.sendLock.Unlock()
+
+	q.lock.Lock()
+	q.clock[q.id] += 1
+	msg.Header.Clock = q.clock.Copy()
+	q.lock.Unlock()
+
+	if err := send(); err != nil {
+		q.lock.Lock()
+		q.clock[q.id] -= 1
+		q.lock.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// receive queues msg, which arrived at now, and returns every message which
+// can now be delivered, in causal order
+func (q *causalQueue) receive(msg *protocol.Message, now time.Time) []*protocol.Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// messages from members not using causal ordering are delivered as is
+	if msg.VectorClock() == nil {
+		return []*protocol.Message{msg}
+	}
+
+	// pick up where a forgotten member is now
+	if sender := msg.Sender(); q.resync[sender] {
+		q.clock[sender] = msg.VectorClock()[sender] - 1
+		delete(q.resync, sender)
+	}
+
+	q.pending = append(q.pending, &pendingMessage{msg: msg, received: now})
+
+	if len(q.pending) > maxCausalPending {
+		q.log.Warn("causal queue full, dropping message", "peer_id", q.pending[0].msg.Sender())
+		q.pending = q.pending[1:]
+	}
+
+	return q.deliverable()
+}
+
+// deliverable removes and returns the pending messages which can be
+// delivered, merging each into the local clock. Dependencies on forgotten
+// members are not waited for. Must hold the lock.
+func (q *causalQueue) deliverable() []*protocol.Message {
+	ready := make([]*protocol.Message, 0)
+
+	for {
+		delivered := false
+
+		for i, p := range q.pending {
+			sender := p.msg.Sender()
+
+			if q.clock.Deliverable(sender, p.msg.VectorClock(), q.resync) {
+				q.clock[sender] = p.msg.VectorClock()[sender]
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				ready = append(ready, p.msg)
+				delivered = true
+
+				break
+			}
+		}
+
+		if !delivered {
+			break
+		}
+	}
+
+	return ready
+}
+
+// forget drops the clock entry of a member which was suspected, failed, left
+// or was reaped, or which introduced itself again, possibly after a restart
+// with a new counter. Its next message sets where the member is.
+func (q *causalQueue) forget(id string) {
+	if id == "" || id == q.id {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.clock, id)
+	q.resync[id] = true
+
+	// messages already held back from it tell us where it is
+	for _, p := range q.pending {
+		if p.msg.Sender() != id {
+			continue
+		}
+
+		if n := p.msg.VectorClock()[id] - 1; q.resync[id] || n < q.clock[id] {
+			q.clock[id] = n
+			delete(q.resync, id)
+		}
+	}
+}
+
+// expire returns every message which can be delivered once the predecessors
+// of messages held back since before now less the pending timeout are given
+// up on
+func (q *causalQueue) expire(now time.Time) []*protocol.Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	ready := q.deliverable()
+
+	// pending is in arrival order, so the expired messages come first
+	for len(q.pending) > 0 && now.Sub(q.pending[0].received) > causalPendingTimeout {
+		p := q.pending[0]
+		q.skip(p.msg)
+
+		q.log.Warn("skipped missing causal predecessors", "peer_id", p.msg.Sender(), "message_id", p.msg.MessageId())
+
+		ready = append(ready, q.deliverable()...)
+
+		// a message which is still stuck was already overtaken and is stale
+		if len(q.pending) > 0 && q.pending[0] == p {
+			q.log.Warn("dropping stale message", "peer_id", p.msg.Sender(), "message_id", p.msg.MessageId())
+			q.pending = q.pending[1:]
+		}
+	}
+
+	return ready
+}
+
+// skip advances the local clock past the missing messages msg depends on,
+// stopping short of those which are still pending so they are delivered
+// first. Must hold the lock.
+func (q *causalQueue) skip(msg *protocol.Message) {
+	sender := msg.Sender()
+
+	for id, n := range msg.VectorClock() {
+		if id == sender {
+			n -= 1
+		}
+
+		for _, p := range q.pending {
+			if k := p.msg.VectorClock()[id]; p.msg.Sender() == id && k <= n {
+				n = k - 1
+			}
+		}
+
+		if n > q.clock[id] {
+			q.clock[id] = n
+		}
+	}
+}
+
+// len returns the number of messages waiting for their causal predecessors
+func (q *causalQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.pending)
+}
@@ -0,0 +1,163 @@
+package busybody
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// causalMessage returns a message from sender stamped with clock
+func causalMessage(sender string, clock protocol.VectorClock) *protocol.Message {
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+	msg.Header.SourceId = sender
+	msg.Header.Clock = clock
+
+	return msg
+}
+
+func TestCausalQueue(t *testing.T) {
+	start := time.Now()
+
+	a1 := causalMessage("a", protocol.VectorClock{"a": 1})
+	a2 := causalMessage("a", protocol.VectorClock{"a": 2})
+	b1 := causalMessage("b", protocol.VectorClock{"a": 1, "b": 1})
+	b2 := causalMessage("b", protocol.VectorClock{"a": 2, "b": 2})
+	c1 := causalMessage("c", protocol.VectorClock{"c": 1})
+	plain := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+
+	// a restarted member starts counting from 1 again
+	restarted := causalMessage("a", protocol.VectorClock{"a": 1})
+
+	tests := []struct {
+		name      string
+		receive   []*protocol.Message
+		forget    []string
+		then      []*protocol.Message
+		after     time.Duration
+		delivered []*protocol.Message
+		pending   int
+	}{
+		{
+			name:      "in order",
+			receive:   []*protocol.Message{a1, b1, a2},
+			delivered: []*protocol.Message{a1, b1, a2},
+		},
+		{
+			name:      "reordered",
+			receive:   []*protocol.Message{b1, a2, a1},
+			delivered: []*protocol.Message{a1, b1, a2},
+		},
+		{
+			name:      "buffered",
+			receive:   []*protocol.Message{a2, b1},
+			delivered: []*protocol.Message{},
+			pending:   2,
+		},
+		{
+			name:      "without a clock",
+			receive:   []*protocol.Message{plain, a2},
+			delivered: []*protocol.Message{plain},
+			pending:   1,
+		},
+		{
+			name:      "forgotten predecessor",
+			receive:   []*protocol.Message{b1},
+			forget:    []string{"a"},
+			delivered: []*protocol.Message{b1},
+		},
+		{
+			name:      "unintroduced sender",
+			receive:   []*protocol.Message{c1, b1},
+			delivered: []*protocol.Message{c1},
+			pending:   1,
+		},
+		{
+			name:      "restarted sender",
+			receive:   []*protocol.Message{a1, a2},
+			forget:    []string{"a"},
+			then:      []*protocol.Message{restarted},
+			delivered: []*protocol.Message{a1, a2, restarted},
+		},
+		{
+			name:      "forgotten sender with pending messages",
+			receive:   []*protocol.Message{a2},
+			forget:    []string{"a"},
+			delivered: []*protocol.Message{a2},
+		},
+		{
+			name:      "pending timeout",
+			receive:   []*protocol.Message{b2, a2},
+			after:     causalPendingTimeout + time.Second,
+			delivered: []*protocol.Message{a2, b2},
+		},
+		{
+			name:      "within timeout",
+			receive:   []*protocol.Message{b2},
+			after:     causalPendingTimeout / 2,
+			delivered: []*protocol.Message{},
+			pending:   1,
+		},
+	}
+
+	for _, test := range tests {
+		q := newCausalQueue("self", NopLogger{})
+		delivered := make([]*protocol.Message, 0)
+
+		for _, msg := range test.receive {
+			delivered = append(delivered, q.receive(msg, start)...)
+		}
+
+		for _, id := range test.forget {
+			q.forget(id)
+
+			// without held back messages from it there is nothing to resync to
+			if _, ok := q.clock[id]; ok && q.len() == 0 {
+				t.Errorf("%s: expected the clock entry of %s to be dropped", test.name, id)
+			}
+		}
+
+		for _, msg := range test.then {
+			delivered = append(delivered, q.receive(msg, start)...)
+		}
+
+		delivered = append(delivered, q.expire(start.Add(test.after))...)
+
+		if len(delivered) != len(test.delivered) {
+			t.Errorf("%s: expected %d messages delivered, found %d", test.name, len(test.delivered), len(delivered))
+			continue
+		}
+
+		for i := range delivered {
+			if delivered[i] != test.delivered[i] {
+				t.Errorf("%s: expected message %d to be %v, found %v", test.name, i, test.delivered[i].VectorClock(), delivered[i].VectorClock())
+			}
+		}
+
+		if q.len() != test.pending {
+			t.Errorf("%s: expected %d pending messages, found %d", test.name, test.pending, q.len())
+		}
+	}
+}
+
+func TestCausalSendRollback(t *testing.T) {
+	q := newCausalQueue("self", NopLogger{})
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "self")
+
+	if err := q.send(msg, func() error { return fmt.Errorf("bus down") }); err == nil {
+		t.Fatal("expected the send error")
+	}
+
+	if q.clock["self"] != 0 {
+		t.Errorf("expected a failed send to roll back the stamp, found %d", q.clock["self"])
+	}
+
+	if err := q.send(msg, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.VectorClock()["self"] != 1 {
+		t.Errorf("expected the next send to reuse the stamp, found %d", msg.VectorClock()["self"])
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zerklabs/busybody"
+)
+
+// how long a leaving agent waits for queued messages to be handled
+const shutdownTimeout = 10 * time.Second
+
+func runAgent(args []string) error {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	config := flags.String("config", "", "path to a TOML config file, settings can also come from BUSYBODY_* variables")
+	admin := flags.String("admin", DefaultAdminAddr, "address to serve the admin endpoint on, empty to disable")
+	flags.Parse(args)
+
+	load := func() (*busybody.BusyConfig, error) {
+		return busybody.LoadConfig(*config, nil)
+	}
+
+	conf, err := load()
+	if err != nil {
+		return err
+	}
+
+	logger := busybody.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	member, err := busybody.NewWithConfig(conf, busybody.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := member.Start(ctx); err != nil {
+		return err
+	}
+
+	member.ReloadOnSignal(load)
+
+	if *admin != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", member.MetricsHandler())
+		mux.Handle("/", member.AdminHandler())
+
+		server := &http.Server{Addr: *admin, Handler: mux}
+		defer server.Close()
+
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("error serving admin endpoint", "addr", *admin, "error", err)
+			}
+		}()
+	}
+
+	logger.Info("agent started", "uri", member.Uri(), "admin", *admin)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case sig := <-stop:
+		logger.Info("leaving the cluster", "signal", sig.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		return member.Shutdown(ctx)
+	case <-member.Done():
+		if err := member.Err(); err != nil {
+			return fmt.Errorf("member stopped: %v", err)
+		}
+
+		return nil
+	}
+}
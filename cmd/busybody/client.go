@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zerklabs/busybody"
+)
+
+// adminFlags returns a flag set with the -addr flag every client command uses
+func adminFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := flags.String("addr", DefaultAdminAddr, "admin address of the agent")
+
+	return flags, addr
+}
+
+// call sends a request to the admin endpoint at addr and decodes the JSON
+// response into out, if given
+func call(addr, method, path string, body, out interface{}) error {
+	var payload bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, "http://"+addr+path, &payload)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+
+		return fmt.Errorf("%s", e.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func runMembers(args []string) error {
+	flags, addr := adminFlags("members")
+	flags.Parse(args)
+
+	var members []struct {
+		Id        string    `json:"id"`
+		Uri       string    `json:"uri"`
+		State     string    `json:"state"`
+		Connected bool      `json:"connected"`
+		Changed   time.Time `json:"changed"`
+	}
+
+	if err := call(*addr, "GET", "/members", nil, &members); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tURI\tSTATE\tCONNECTED\tCHANGED")
+
+	for _, p := range members {
+		changed := "-"
+		if !p.Changed.IsZero() {
+			changed = p.Changed.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", p.Id, p.Uri, p.State, p.Connected, changed)
+	}
+
+	return w.Flush()
+}
+
+func runJoin(args []string) error {
+	flags, addr := adminFlags("join")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("at least one seed uri required")
+	}
+
+	var resp struct {
+		Reached int `json:"reached"`
+	}
+
+	if err := call(*addr, "POST", "/join", map[string][]string{"seeds": flags.Args()}, &resp); err != nil {
+		return err
+	}
+
+	fmt.Printf("reached %d of %d seeds\n", resp.Reached, flags.NArg())
+
+	return nil
+}
+
+func runLeave(args []string) error {
+	flags, addr := adminFlags("leave")
+	flags.Parse(args)
+
+	return call(*addr, "POST", "/leave", nil, nil)
+}
+
+func runSend(args []string) error {
+	flags, addr := adminFlags("send")
+	topic := flags.String("topic", "", "topic to publish the message to")
+	ttl := flags.Duration("ttl", 0, "drop the message if not delivered within this duration")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: busybody send [flags] <payload>")
+	}
+
+	body := map[string]string{"payload": flags.Arg(0), "topic": *topic}
+	if *ttl > 0 {
+		body["ttl"] = ttl.String()
+	}
+
+	return call(*addr, "POST", "/send", body, nil)
+}
+
+func runEvent(args []string) error {
+	flags, addr := adminFlags("event")
+	coalesce := flags.Bool("coalesce", false, "deliver only the latest of several events with this name")
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("usage: busybody event [flags] <name> [payload]")
+	}
+
+	body := map[string]interface{}{"name": flags.Arg(0), "payload": flags.Arg(1), "coalesce": *coalesce}
+
+	return call(*addr, "POST", "/event", body, nil)
+}
+
+func runMonitor(args []string) error {
+	flags, addr := adminFlags("monitor")
+	raw := flags.Bool("json", false, "print the raw JSON entries")
+	flags.Parse(args)
+
+	resp, err := http.Get("http://" + *addr + "/monitor")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /monitor: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+
+	for scanner.Scan() {
+		if *raw {
+			fmt.Println(scanner.Text())
+			continue
+		}
+
+		var entry busybody.MonitorEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("error decoding monitor entry: %v", err)
+		}
+
+		fmt.Println(formatEntry(&entry))
+	}
+
+	return scanner.Err()
+}
+
+// formatEntry renders a monitor entry as a single line
+func formatEntry(e *busybody.MonitorEntry) string {
+	ts := e.Time.Format("15:04:05.000")
+
+	switch {
+	case e.Member != nil:
+		return fmt.Sprintf("%s member  %-8s %s %s", ts, e.Member.Kind, e.Member.Peer, e.Member.PeerId)
+	case e.Message != nil:
+		topic := ""
+		if e.Message.Topic != "" {
+			topic = " [" + e.Message.Topic + "]"
+		}
+
+		return fmt.Sprintf("%s message from %s%s: %s", ts, e.Message.Sender, topic, printable(e.Message.Body))
+	case e.Event != nil:
+		return fmt.Sprintf("%s event   %s from %s: %s", ts, e.Event.Name, e.Event.Origin, printable(e.Event.Payload))
+	}
+
+	return fmt.Sprintf("%s %s", ts, e.Kind)
+}
+
+// printable returns b as text, or a short summary if it is not valid text
+func printable(b []byte) string {
+	s := string(b)
+
+	if strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 && r != '\t' }) || !utf8.Valid(b) {
+		return fmt.Sprintf("<%d bytes>", len(b))
+	}
+
+	return s
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zerklabs/busybody"
+	"github.com/zerklabs/busybody/protocol"
+)
+
+func runDecode(args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	isHex := flags.Bool("hex", false, "input is hex encoded, whitespace is ignored")
+	body := flags.String("body", "auto", "show the body as auto, hex, text or none")
+	showKey := flags.Bool("show-key", false, "show the shared key of introductions instead of redacting it")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: busybody decode [flags] [file|-|hex]\n\nreads a frame from file, or stdin if none or -\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	frame, err := readFrame(flags.Arg(0), *isHex)
+	if err != nil {
+		return err
+	}
+
+	insp, err := protocol.Inspect(frame)
+	printHeader(insp)
+
+	if err != nil {
+		return err
+	}
+
+	printBody(insp.Body, *body)
+
+	switch insp.Header.MsgType {
+	case protocol.HelloMessage, protocol.LeaveMessage:
+		intro, err := busybody.UnmarshalIntroduction(insp.Message)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("<redacted, %d bytes>", len(intro.Key))
+		if *showKey || intro.Key == "" {
+			key = intro.Key
+		}
+
+		fmt.Printf("\nintroduction:\n  id:   %s\n  uri:  %s\n  key:  %s\n", intro.Id, intro.Uri, key)
+	case protocol.UserEventMessage:
+		event, err := busybody.UnmarshalUserEvent(insp.Message)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\nuser event:\n  name:     %s\n  origin:   %s\n  ltime:    %d\n  coalesce: %t\n  payload:  %s\n",
+			event.Name, event.Origin, event.LTime, event.Coalesce, printable(event.Payload))
+	}
+
+	return nil
+}
+
+// readFrame reads a frame from a file, stdin, or, with isHex, a hex string
+// given as the argument itself
+func readFrame(arg string, isHex bool) ([]byte, error) {
+	var raw []byte
+	var err error
+
+	switch {
+	case arg == "" || arg == "-":
+		raw, err = ioutil.ReadAll(os.Stdin)
+	case isHex && !fileExists(arg):
+		raw = []byte(arg)
+	default:
+		raw, err = ioutil.ReadFile(arg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isHex {
+		return raw, nil
+	}
+
+	clean := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \t\r\n:", r) {
+			return -1
+		}
+		return r
+	}, string(raw))
+	clean = strings.TrimPrefix(clean, "0x")
+
+	frame, err := hex.DecodeString(clean)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex input: %v", err)
+	}
+
+	return frame, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// printHeader prints whatever part of the header was decoded
+func printHeader(insp *protocol.Inspection) {
+	if insp == nil || insp.HeaderLen == 0 {
+		return
+	}
+
+	h := &insp.Header
+
+	fmt.Printf("header (%d bytes, body at offset %d):\n", insp.HeaderLen, insp.BodyOffset)
+	fmt.Printf("  version:      %d\n", h.Version)
+	fmt.Printf("  type:         %s (%d)\n", insp.Type, h.MsgType)
+	fmt.Printf("  compression:  %s (%d)\n", insp.Compression, h.CompressionType)
+	fmt.Printf("  source:       %s\n", h.SourceId)
+	fmt.Printf("  timestamp:    %s\n", insp.Timestamp.Format(time.RFC3339Nano))
+	fmt.Printf("  body length:  %d (%d compressed)\n", h.BodyLen, h.CompBodyLen)
+
+	if !insp.Deadline.IsZero() {
+		fmt.Printf("  deadline:     %s\n", insp.Deadline.Format(time.RFC3339Nano))
+	}
+
+	if h.FragCount > 1 {
+		fmt.Printf("  fragment:     %d of %d (message %s)\n", h.FragIndex+1, h.FragCount, h.MessageId)
+	}
+
+	if h.Topic != "" {
+		fmt.Printf("  topic:        %s\n", h.Topic)
+	}
+
+	if h.Codec != "" {
+		fmt.Printf("  codec:        %s (%s)\n", h.Codec, h.TypeName)
+	}
+
+	if len(h.Clock) > 0 {
+		fmt.Printf("  vector clock: %v\n", h.Clock)
+	}
+}
+
+// printBody prints the decompressed body in the requested form
+func printBody(body []byte, mode string) {
+	if mode == "none" {
+		return
+	}
+
+	if mode == "auto" {
+		mode = "hex"
+		if utf8.Valid(body) && printable(body) == string(body) {
+			mode = "text"
+		}
+	}
+
+	fmt.Printf("\nbody (%d bytes):\n", len(body))
+
+	if mode == "text" {
+		fmt.Println(string(body))
+		return
+	}
+
+	io.WriteString(os.Stdout, hex.Dump(body))
+}
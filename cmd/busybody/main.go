@@ -0,0 +1,72 @@
+// Command busybody runs a busybody member and controls running members
+// through their admin endpoint.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// DefaultAdminAddr is where the agent serves, and the other commands look
+// for, the admin endpoint
+const DefaultAdminAddr = "127.0.0.1:8946"
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"agent", "run a member from a config file", runAgent},
+		{"members", "list the members known to an agent", runMembers},
+		{"join", "make an agent join the given seeds", runJoin},
+		{"leave", "make an agent leave the cluster and stop", runLeave},
+		{"send", "send a message through an agent", runSend},
+		{"event", "gossip a user event through an agent", runEvent},
+		{"monitor", "stream membership changes, messages and events", runMonitor},
+		{"decode", "decode a captured frame", runDecode},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: busybody <command> [flags] [args]\n\ncommands:\n")
+
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nrun busybody <command> -h for the flags of a command\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+
+		if err := c.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "busybody %s: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if name != "-h" && name != "help" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+	}
+
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,148 @@
+package busybody
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// Codec encodes application values into message bodies
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Name() string { return "gob" }
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buffer).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    = map[string]Codec{
+		"json": JSONCodec{},
+		"gob":  GobCodec{},
+	}
+)
+
+// RegisterCodec makes a codec available to every member by its name
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+// LookupCodec returns the codec registered under name
+func LookupCodec(name string) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+
+	return c, nil
+}
+
+// TypeName returns the name receivers use to route values of the type of v.
+// Pointers are named after the type they point to.
+func TypeName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == nil {
+		return ""
+	}
+
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+
+	return t.String()
+}
+
+// SendTyped encodes v with the configured codec and sends it with its type
+// name, so receivers can route it to a TypedHandler
+func (m *BusyMember) SendTyped(v interface{}, opts ...SendOption) error {
+	codec, err := LookupCodec(m.conf().Codec)
+	if err != nil {
+		return err
+	}
+
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %T with %s: %v", v, codec.Name(), err)
+	}
+
+	typed := func(msg *protocol.Message) {
+		msg.Header.Codec = codec.Name()
+		msg.Header.TypeName = TypeName(v)
+	}
+
+	return m.Send(body, append([]SendOption{typed}, opts...)...)
+}
+
+// TypedHandler returns a handler which decodes messages sent with SendTyped
+// for values of type T. Messages of any other type are ignored.
+func TypedHandler[T any](fn func(ctx context.Context, env *Envelope, v T) error) ContextHandler {
+	var zero T
+	name := TypeName(&zero)
+
+	return ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		if env.Message.TypeName() != name {
+			return nil
+		}
+
+		codec, err := LookupCodec(env.Message.Codec())
+		if err != nil {
+			return err
+		}
+
+		body, err := env.Message.Body()
+		if err != nil {
+			return err
+		}
+
+		var v T
+		if err := codec.Unmarshal(body, &v); err != nil {
+			return fmt.Errorf("error decoding %s with %s: %v", name, codec.Name(), err)
+		}
+
+		return fn(ctx, env, v)
+	})
+}
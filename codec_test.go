@@ -0,0 +1,59 @@
+package busybody
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+type testPayload struct {
+	Name  string
+	Count int
+}
+
+func TestTypedHandler(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, GobCodec{}} {
+		body, err := codec.Marshal(&testPayload{Name: "deploy", Count: 3})
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+		msg.Header.Codec = codec.Name()
+		msg.Header.TypeName = TypeName(testPayload{})
+		msg.Write(body)
+
+		var received *testPayload
+
+		h := TypedHandler(func(ctx context.Context, env *Envelope, v testPayload) error {
+			received = &v
+			return nil
+		})
+
+		if err := h.HandleMessageContext(context.Background(), &Envelope{Message: msg}); err != nil {
+			t.Error(err)
+		}
+
+		if received == nil || received.Name != "deploy" || received.Count != 3 {
+			t.Errorf("%s: expected the payload to be decoded, found %#v", codec.Name(), received)
+		}
+	}
+}
+
+func TestTypedHandlerIgnoresOtherTypes(t *testing.T) {
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+	msg.Header.Codec = "json"
+	msg.Header.TypeName = "other.Type"
+	msg.Write([]byte("{}"))
+
+	h := TypedHandler(func(ctx context.Context, env *Envelope, v testPayload) error {
+		t.Errorf("expected messages of other types to be ignored")
+		return nil
+	})
+
+	if err := h.HandleMessageContext(context.Background(), &Envelope{Message: msg}); err != nil {
+		t.Error(err)
+	}
+}
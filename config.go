@@ -2,53 +2,387 @@ package busybody
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/zerklabs/busybody/protocol"
 )
 
 const DefaultSwimInterval = "2m0s"
 const DefaultSwimTimeout = "1m0s"
+const DefaultEventCoalescePeriod = "1s"
+const DefaultEventRetransmit = 3
+const DefaultEventBufferSize = 512
+const DefaultMaxFragmentSize = 1 << 20
+const DefaultFragmentTimeout = "30s"
+const DefaultFragmentMemoryLimit = 512 << 20
+const DefaultHandlerWorkers = 1
+const DefaultHandlerQueueSize = 256
+const DefaultOverflowPolicy = OverflowBlock
+const DefaultCodec = "json"
+const DefaultRedialAttempts = 8
+const DefaultMaxRedialBackoff = "1m0s"
+const DefaultTombstoneTimeout = "24h0m0s"
+const DefaultReconnectInterval = "30s"
+
+// EnvPrefix is prepended to the upper cased TOML key to name the environment
+// variable for a setting, e.g. BUSYBODY_URI or BUSYBODY_SWIM_INTERVAL
+const EnvPrefix = "BUSYBODY_"
 
 type BusyConfig struct {
+	CausalOrdering          bool          `toml:"causal_ordering"`
+	Codec                   string        `toml:"codec"`
 	DeflateCompression      bool          `toml:"deflate_compression"`
 	DeflateCompressionLevel int           `toml:"deflate_compression_level"`
+	EventBufferSize         int           `toml:"event_buffer_size"`
+	EventCoalescePeriodStr  string        `toml:"event_coalesce_period"`
+	EventCoalescePeriod     time.Duration `toml:"-"`
+	EventRetransmit         int           `toml:"event_retransmit"`
+	FormatVersion           int           `toml:"format_version"`
+	FragmentMemoryLimit     int           `toml:"fragment_memory_limit"`
+	FragmentTimeoutStr      string        `toml:"fragment_timeout"`
+	FragmentTimeout         time.Duration `toml:"-"`
+	HandlerQueueSize        int           `toml:"handler_queue_size"`
+	HandlerWorkers          int           `toml:"handler_workers"`
 	LogLevel                int           `toml:"log_level"`
+	Logger                  Logger        `toml:"-"`
+	MaxFragmentSize         int           `toml:"max_fragment_size"`
+	MaxRedialBackoffStr     string        `toml:"max_redial_backoff"`
+	MaxRedialBackoff        time.Duration `toml:"-"`
+	OverflowPolicy          string        `toml:"overflow_policy"`
 	Peers                   []string      `toml:"peers"`
+	ReconnectIntervalStr    string        `toml:"reconnect_interval"`
+	ReconnectInterval       time.Duration `toml:"-"`
+	RedialAttempts          int           `toml:"redial_attempts"`
 	SharedKey               string        `toml:"shared_key"`
 	SnappyCompression       bool          `toml:"snappy_compression"`
 	SwimIntervalStr         string        `toml:"swim_interval"`
 	SwimTimeoutStr          string        `toml:"swim_timeout"`
 	SwimInterval            time.Duration `toml:"-"`
 	SwimTimeout             time.Duration `toml:"-"`
+	TombstoneTimeoutStr     string        `toml:"tombstone_timeout"`
+	TombstoneTimeout        time.Duration `toml:"-"`
 	Uri                     string        `toml:"uri"`
 	ZlibCompression         bool          `toml:"zlib_compression"`
 }
 
+// ParseConfig decodes a TOML document and validates it
 func ParseConfig(config []byte) (*BusyConfig, error) {
 	var conf BusyConfig
-	if _, err := toml.Decode(string(config), &conf); err != nil {
+	if err := conf.decode(config); err != nil {
 		return nil, err
 	}
 
-	if conf.Uri == "" {
-		return nil, fmt.Errorf("uri required in config")
+	if err := conf.Validate(); err != nil {
+		return nil, err
 	}
 
-	if conf.SwimIntervalStr == "" {
-		conf.SwimIntervalStr = DefaultSwimInterval
+	return &conf, nil
+}
+
+// LoadConfig builds a configuration from several layers. From lowest to
+// highest precedence they are:
+//
+//  1. the defaults
+//  2. the TOML file at path, skipped when path is empty
+//  3. environment variables named EnvPrefix plus the upper cased TOML key
+//  4. overrides, keyed by TOML key
+//
+// Each layer only replaces the settings it sets. Environment variables and
+// overrides are given as strings; peers is a comma separated list and empty
+// values are ignored.
+func LoadConfig(path string, overrides map[string]string) (*BusyConfig, error) {
+	var conf BusyConfig
+
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading config file: %v", err)
+		}
+
+		if err := conf.decode(b); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %v", path, err)
+		}
 	}
 
-	if conf.SwimTimeoutStr == "" {
-		conf.SwimTimeoutStr = DefaultSwimTimeout
+	if err := conf.applyEnv(); err != nil {
+		return nil, err
 	}
 
-	conf.SwimTimeout, _ = time.ParseDuration(DefaultSwimTimeout)
-	conf.SwimInterval, _ = time.ParseDuration(DefaultSwimInterval)
+	for key, value := range overrides {
+		if err := conf.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
 
-	if conf.SnappyCompression && conf.DeflateCompression && conf.ZlibCompression {
-		return nil, fmt.Errorf("only one of snappy, deflate or zlib can be used")
+	if err := conf.Validate(); err != nil {
+		return nil, err
 	}
 
 	return &conf, nil
 }
+
+// decode merges a TOML document into the configuration
+func (conf *BusyConfig) decode(config []byte) error {
+	_, err := toml.Decode(string(config), conf)
+	return err
+}
+
+// applyEnv merges the BUSYBODY_* environment variables into the configuration
+func (conf *BusyConfig) applyEnv() error {
+	for _, key := range configKeys() {
+		value := os.Getenv(EnvPrefix + strings.ToUpper(key))
+		if value == "" {
+			continue
+		}
+
+		if err := conf.Set(key, value); err != nil {
+			return fmt.Errorf("%v (from %s%s)", err, EnvPrefix, strings.ToUpper(key))
+		}
+	}
+
+	return nil
+}
+
+// configKeys returns the TOML keys of every setting
+func configKeys() []string {
+	t := reflect.TypeOf(BusyConfig{})
+	keys := make([]string, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("toml"); key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Set assigns a setting from its string form, using the TOML key to name it.
+// The value is only checked against the type of the setting; call Validate
+// once every setting is in place. Durations are parsed right away, since the
+// parsed form takes precedence over the string once a configuration has been
+// validated.
+func (conf *BusyConfig) Set(key, value string) error {
+	v := reflect.ValueOf(conf).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("toml"); tag != key || tag == "-" {
+			continue
+		}
+
+		field := v.Field(i)
+
+		switch field.Kind() {
+		case reflect.String:
+			if err := conf.setDuration(t.Field(i).Name, key, value); err != nil {
+				return err
+			}
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", key, err)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", key, err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Slice:
+			list := make([]string, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+		default:
+			return fmt.Errorf("invalid %s: unsupported setting type", key)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown setting: %s", key)
+}
+
+// setDuration parses value into the Duration paired with the string field
+// name, e.g. SwimInterval for SwimIntervalStr. Other fields are left alone.
+func (conf *BusyConfig) setDuration(name, key, value string) error {
+	if !strings.HasSuffix(name, "Str") {
+		return nil
+	}
+
+	field := reflect.ValueOf(conf).Elem().FieldByName(strings.TrimSuffix(name, "Str"))
+	if !field.IsValid() || field.Type() != reflect.TypeOf(time.Duration(0)) {
+		return nil
+	}
+
+	var d time.Duration
+	if value != "" {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+		d = v
+	}
+
+	field.SetInt(int64(d))
+
+	return nil
+}
+
+// parseDuration fills in a duration setting. Once set the Duration is the
+// source of truth, so changing it in code after the configuration was parsed
+// takes effect and the string form is rewritten to match. The string form is
+// only parsed while the Duration is unset, and def is used when both are.
+func parseDuration(key string, str *string, d *time.Duration, def string) error {
+	if *d != 0 {
+		*str = d.String()
+		return nil
+	}
+
+	if *str == "" {
+		*str = def
+	}
+
+	v, err := time.ParseDuration(*str)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", key, err)
+	}
+
+	*d = v
+
+	return nil
+}
+
+// Validate checks the configuration and fills in defaults for anything not
+// set. It is used for configurations decoded from TOML and built in code.
+func (conf *BusyConfig) Validate() error {
+	if conf.Uri == "" {
+		return fmt.Errorf("uri required in config")
+	}
+
+	if err := parseDuration("swim_interval", &conf.SwimIntervalStr, &conf.SwimInterval, DefaultSwimInterval); err != nil {
+		return err
+	}
+
+	if err := parseDuration("swim_timeout", &conf.SwimTimeoutStr, &conf.SwimTimeout, DefaultSwimTimeout); err != nil {
+		return err
+	}
+
+	if conf.SwimInterval <= 0 {
+		return fmt.Errorf("invalid swim_interval: must be positive")
+	}
+
+	if conf.SwimTimeout <= 0 {
+		return fmt.Errorf("invalid swim_timeout: must be positive")
+	}
+
+	if conf.SwimTimeout >= conf.SwimInterval {
+		return fmt.Errorf("invalid swim_timeout: %s must be less than swim_interval (%s)", conf.SwimTimeout, conf.SwimInterval)
+	}
+
+	if err := parseDuration("event_coalesce_period", &conf.EventCoalescePeriodStr, &conf.EventCoalescePeriod, DefaultEventCoalescePeriod); err != nil {
+		return err
+	}
+
+	if conf.EventRetransmit <= 0 {
+		conf.EventRetransmit = DefaultEventRetransmit
+	}
+
+	if conf.EventBufferSize <= 0 {
+		conf.EventBufferSize = DefaultEventBufferSize
+	}
+
+	if conf.FormatVersion == 0 {
+		conf.FormatVersion = protocol.DefaultFormatVersion
+	}
+
+	if conf.FormatVersion < 1 || conf.FormatVersion > protocol.FormatVersion {
+		return fmt.Errorf("invalid format_version: must be between 1 and %d", protocol.FormatVersion)
+	}
+
+	if conf.MaxFragmentSize <= 0 {
+		conf.MaxFragmentSize = DefaultMaxFragmentSize
+	}
+
+	if conf.FragmentMemoryLimit <= 0 {
+		conf.FragmentMemoryLimit = DefaultFragmentMemoryLimit
+	}
+
+	if err := parseDuration("fragment_timeout", &conf.FragmentTimeoutStr, &conf.FragmentTimeout, DefaultFragmentTimeout); err != nil {
+		return err
+	}
+
+	if conf.HandlerWorkers <= 0 {
+		conf.HandlerWorkers = DefaultHandlerWorkers
+	}
+
+	// more than one worker would hand messages to handlers out of order
+	if conf.CausalOrdering && conf.HandlerWorkers > 1 {
+		return fmt.Errorf("causal_ordering requires handler_workers = 1")
+	}
+
+	if conf.HandlerQueueSize <= 0 {
+		conf.HandlerQueueSize = DefaultHandlerQueueSize
+	}
+
+	if conf.OverflowPolicy == "" {
+		conf.OverflowPolicy = DefaultOverflowPolicy
+	}
+
+	if err := validOverflowPolicy(conf.OverflowPolicy); err != nil {
+		return fmt.Errorf("invalid overflow_policy: %v", err)
+	}
+
+	if conf.Codec == "" {
+		conf.Codec = DefaultCodec
+	}
+
+	if _, err := LookupCodec(conf.Codec); err != nil {
+		return fmt.Errorf("invalid codec: %v", err)
+	}
+
+	if conf.RedialAttempts <= 0 {
+		conf.RedialAttempts = DefaultRedialAttempts
+	}
+
+	if err := parseDuration("max_redial_backoff", &conf.MaxRedialBackoffStr, &conf.MaxRedialBackoff, DefaultMaxRedialBackoff); err != nil {
+		return err
+	}
+
+	if err := parseDuration("tombstone_timeout", &conf.TombstoneTimeoutStr, &conf.TombstoneTimeout, DefaultTombstoneTimeout); err != nil {
+		return err
+	}
+
+	if err := parseDuration("reconnect_interval", &conf.ReconnectIntervalStr, &conf.ReconnectInterval, DefaultReconnectInterval); err != nil {
+		return err
+	}
+
+	if conf.ReconnectInterval <= 0 {
+		return fmt.Errorf("invalid reconnect_interval: must be positive")
+	}
+
+	enabled := 0
+	for _, on := range []bool{conf.SnappyCompression, conf.DeflateCompression, conf.ZlibCompression} {
+		if on {
+			enabled++
+		}
+	}
+
+	if enabled > 1 {
+		return fmt.Errorf("invalid compression: only one of snappy_compression, deflate_compression or zlib_compression can be enabled")
+	}
+
+	return nil
+}
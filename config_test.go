@@ -0,0 +1,141 @@
+package busybody
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigDurations(t *testing.T) {
+	conf, err := ParseConfig([]byte(`
+uri = "ipc:///tmp/ipc0.ipc"
+swim_interval = "5s"
+swim_timeout = "2s"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.SwimInterval != 5*time.Second {
+		t.Errorf("expected swim_interval of 5s, got %s", conf.SwimInterval)
+	}
+
+	if conf.SwimTimeout != 2*time.Second {
+		t.Errorf("expected swim_timeout of 2s, got %s", conf.SwimTimeout)
+	}
+}
+
+func TestConfigDurationChanges(t *testing.T) {
+	conf, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a duration changed in code after parsing takes effect
+	conf.SwimInterval = 90 * time.Second
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.SwimInterval != 90*time.Second || conf.SwimIntervalStr != "1m30s" {
+		t.Errorf("expected swim_interval of 1m30s, got %s (%q)", conf.SwimInterval, conf.SwimIntervalStr)
+	}
+
+	// and so does one changed through its string form
+	if err := conf.Set("swim_interval", "3m"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.SwimInterval != 3*time.Minute {
+		t.Errorf("expected swim_interval of 3m, got %s", conf.SwimInterval)
+	}
+
+	if err := conf.Set("swim_interval", "soon"); err == nil {
+		t.Errorf("expected an invalid duration to be rejected by Set")
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	tests := []struct {
+		config string
+		key    string
+	}{
+		{`swim_interval = "5 seconds"`, "swim_interval"},
+		{`swim_timeout = "soon"`, "swim_timeout"},
+		{"swim_interval = \"5s\"\nswim_timeout = \"10s\"", "swim_timeout"},
+		{`fragment_timeout = "-"`, "fragment_timeout"},
+		{"snappy_compression = true\nzlib_compression = true", "compression"},
+		{"causal_ordering = true\nhandler_workers = 2", "causal_ordering"},
+		{"format_version = 3", "format_version"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseConfig([]byte("uri = \"ipc:///tmp/ipc0.ipc\"\n" + test.config))
+		if err == nil {
+			t.Errorf("expected %q to be rejected", test.config)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.key) {
+			t.Errorf("expected the error for %q to name %s, got: %v", test.config, test.key, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "busybody.conf")
+	file := `
+uri = "ipc:///tmp/file.ipc"
+shared_key = "file_key"
+swim_interval = "10s"
+swim_timeout = "5s"
+`
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BUSYBODY_URI", "ipc:///tmp/env.ipc")
+	t.Setenv("BUSYBODY_PEERS", "ipc:///tmp/ipc1.ipc, ipc:///tmp/ipc2.ipc")
+	t.Setenv("BUSYBODY_SWIM_TIMEOUT", "2s")
+	t.Setenv("BUSYBODY_SNAPPY_COMPRESSION", "true")
+
+	conf, err := LoadConfig(path, map[string]string{"uri": "ipc:///tmp/override.ipc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Uri != "ipc:///tmp/override.ipc" {
+		t.Errorf("expected the override to win, got uri %s", conf.Uri)
+	}
+
+	if conf.SharedKey != "file_key" {
+		t.Errorf("expected the shared key from the file, got %q", conf.SharedKey)
+	}
+
+	if conf.SwimInterval != 10*time.Second || conf.SwimTimeout != 2*time.Second {
+		t.Errorf("expected swim interval 10s and timeout 2s, got %s and %s", conf.SwimInterval, conf.SwimTimeout)
+	}
+
+	if len(conf.Peers) != 2 || conf.Peers[1] != "ipc:///tmp/ipc2.ipc" {
+		t.Errorf("expected 2 peers from the environment, got %v", conf.Peers)
+	}
+
+	if !conf.SnappyCompression {
+		t.Errorf("expected snappy compression to be enabled from the environment")
+	}
+
+	t.Setenv("BUSYBODY_EVENT_RETRANSMIT", "lots")
+	if _, err := LoadConfig(path, nil); err == nil || !strings.Contains(err.Error(), "BUSYBODY_EVENT_RETRANSMIT") {
+		t.Errorf("expected an error naming BUSYBODY_EVENT_RETRANSMIT, got: %v", err)
+	}
+
+	if err := new(BusyConfig).Set("no_such_key", "1"); err == nil {
+		t.Errorf("expected an unknown setting to be rejected")
+	}
+}
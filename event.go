@@ -0,0 +1,265 @@
+package busybody
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// how often queued user events are retransmitted
+const eventGossipInterval = 200 * time.Millisecond
+
+// UserEvent is an application defined event gossiped to every member
+type UserEvent struct {
+	LTime    uint64
+	Name     string
+	Payload  []byte
+	Coalesce bool
+	Origin   string
+}
+
+// eventKey identifies an event for duplicate detection. Every event from an
+// origin is stamped with a new Lamport time, so no two events share one.
+type eventKey struct {
+	origin string
+	ltime  uint64
+	name   string
+}
+
+func (e *UserEvent) key() eventKey {
+	return eventKey{origin: e.Origin, ltime: e.LTime, name: e.Name}
+}
+
+// queuedEvent is an event waiting to be retransmitted
+type queuedEvent struct {
+	event     *UserEvent
+	transmits int
+}
+
+// eventBuffer remembers the events seen within the last size Lamport times
+type eventBuffer struct {
+	lock  sync.Mutex
+	slots []eventSlot
+}
+
+type eventSlot struct {
+	ltime uint64
+	keys  map[eventKey]bool
+}
+
+func newEventBuffer(size int) *eventBuffer {
+	return &eventBuffer{slots: make([]eventSlot, size)}
+}
+
+// seen records the event and reports whether it was either already seen or
+// too old to be tracked against the current time
+func (b *eventBuffer) seen(e *UserEvent, now uint64) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	size := uint64(len(b.slots))
+	if now > size && e.LTime < now-size {
+		return true
+	}
+
+	slot := &b.slots[e.LTime%size]
+	if slot.ltime != e.LTime || slot.keys == nil {
+		slot.ltime = e.LTime
+		slot.keys = make(map[eventKey]bool)
+	}
+
+	key := e.key()
+	if slot.keys[key] {
+		return true
+	}
+
+	slot.keys[key] = true
+
+	return false
+}
+
+// coalescer holds back coalescing events so only the latest event of a
+// name within the coalesce period is delivered. Events older than the latest
+// one already delivered under their name are dropped.
+type coalescer struct {
+	lock      sync.Mutex
+	period    time.Duration
+	latest    map[string]*UserEvent
+	delivered map[string]uint64
+	deliver   func(*UserEvent)
+}
+
+func newCoalescer(period time.Duration, deliver func(*UserEvent)) *coalescer {
+	return &coalescer{
+		period:    period,
+		latest:    make(map[string]*UserEvent),
+		delivered: make(map[string]uint64),
+		deliver:   deliver,
+	}
+}
+
+func (c *coalescer) add(e *UserEvent) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if last, ok := c.delivered[e.Name]; ok && e.LTime <= last {
+		return
+	}
+
+	current, pending := c.latest[e.Name]
+	if !pending {
+		time.AfterFunc(c.period, func() { c.flush(e.Name) })
+	}
+
+	if !pending || e.LTime > current.LTime {
+		c.latest[e.Name] = e
+	}
+}
+
+func (c *coalescer) flush(name string) {
+	c.lock.Lock()
+	e := c.latest[name]
+	delete(c.latest, name)
+	if e != nil {
+		c.delivered[name] = e.LTime
+	}
+	c.lock.Unlock()
+
+	if e != nil {
+		c.deliver(e)
+	}
+}
+
+// UserEvent stamps a named event with the cluster Lamport time and gossips it
+// to every member. With coalesce set, receivers only deliver the latest event
+// of the given name seen within the coalesce period.
+func (m *BusyMember) UserEvent(name string, payload []byte, coalesce bool) error {
+	if name == "" {
+		return fmt.Errorf("event name cannot be empty")
+	}
+
+	event := &UserEvent{
+		LTime:    m.eventClock.Increment(),
+		Name:     name,
+		Payload:  payload,
+		Coalesce: coalesce,
+		Origin:   m.id,
+	}
+
+	m.receiveEvent(event)
+
+	return m.sendEvent(event)
+}
+
+func (m *BusyMember) AddEventHandler(handler EventHandler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.eventHandlers = append(m.eventHandlers, handler)
+}
+
+func UnmarshalUserEvent(p *protocol.Message) (*UserEvent, error) {
+	var event UserEvent
+
+	if p.MessageType() != protocol.UserEventMessage {
+		return nil, fmt.Errorf("not a user event message")
+	}
+
+	body, err := p.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gob.NewDecoder(bytes.NewBuffer(body)).Decode(&event); err != nil {
+		return nil, fmt.Errorf("error gob decoding user event: %v", err)
+	}
+
+	if event.Name == "" {
+		return nil, fmt.Errorf("invalid user event: name missing")
+	}
+
+	return &event, nil
+}
+
+func (m *BusyMember) eventmsg() *protocol.Message {
+	msg := m.defaultMessage()
+	msg.Header.MsgType = protocol.UserEventMessage
+
+	return msg
+}
+
+func (m *BusyMember) sendEvent(event *UserEvent) error {
+	buffer := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buffer).Encode(event); err != nil {
+		return fmt.Errorf("error gob encoding user event: %v", err)
+	}
+
+	msg := m.eventmsg()
+	if _, err := msg.Write(buffer.Bytes()); err != nil {
+		return fmt.Errorf("error writing content to message: %v", err)
+	}
+
+	return m.send(msg)
+}
+
+// receiveEvent drops duplicate events, queues new ones for retransmission
+// and delivers them to the event handlers
+func (m *BusyMember) receiveEvent(event *UserEvent) {
+	m.eventClock.Witness(event.LTime)
+
+	if m.eventSeen.seen(event, m.eventClock.Time()) {
+		return
+	}
+
+	m.eventLock.Lock()
+	m.eventQueue = append(m.eventQueue, &queuedEvent{event: event, transmits: m.conf().EventRetransmit})
+	m.eventLock.Unlock()
+
+	if event.Coalesce {
+		m.coalescer.add(event)
+		return
+	}
+
+	m.deliverEvent(event)
+}
+
+func (m *BusyMember) deliverEvent(event *UserEvent) {
+	m.publish(func() MonitorEntry {
+		return MonitorEntry{Kind: MonitorKindEvent, Event: &MonitorUserEvent{Name: event.Name, Origin: event.Origin, LTime: event.LTime, Payload: event.Payload}}
+	})
+
+	m.lock.RLock()
+	handlers := m.eventHandlers
+	m.lock.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler.HandleEvent(event); err != nil {
+			m.log.Error("error during HandleEvent", "event", event.Name, "origin", event.Origin, "error", err)
+		}
+	}
+}
+
+// gossipEvents retransmits every queued event, dropping those which have
+// been sent the configured number of times
+func (m *BusyMember) gossipEvents() {
+	m.eventLock.Lock()
+	queue := m.eventQueue
+	m.eventQueue = make([]*queuedEvent, 0, len(queue))
+
+	for _, q := range queue {
+		if q.transmits > 1 {
+			m.eventQueue = append(m.eventQueue, &queuedEvent{event: q.event, transmits: q.transmits - 1})
+		}
+	}
+	m.eventLock.Unlock()
+
+	for _, q := range queue {
+		if err := m.sendEvent(q.event); err != nil {
+			m.log.Error("error gossiping event", "event", q.event.Name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package busybody
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBufferSeen(t *testing.T) {
+	b := newEventBuffer(8)
+
+	tests := []struct {
+		name  string
+		event *UserEvent
+		now   uint64
+		seen  bool
+	}{
+		{"new", &UserEvent{LTime: 5, Name: "deploy", Origin: "a", Payload: []byte("v1")}, 5, false},
+		{"duplicate", &UserEvent{LTime: 5, Name: "deploy", Origin: "a", Payload: []byte("v1")}, 5, true},
+		{"other origin", &UserEvent{LTime: 5, Name: "deploy", Origin: "b", Payload: []byte("v1")}, 5, false},
+		{"other name", &UserEvent{LTime: 5, Name: "restart", Origin: "a"}, 5, false},
+		{"retransmit with new payload", &UserEvent{LTime: 5, Name: "deploy", Origin: "a", Payload: []byte("v2")}, 5, true},
+		{"newer", &UserEvent{LTime: 6, Name: "deploy", Origin: "a"}, 6, false},
+		{"too old", &UserEvent{LTime: 1, Name: "deploy", Origin: "c"}, 20, true},
+	}
+
+	for _, test := range tests {
+		if seen := b.seen(test.event, test.now); seen != test.seen {
+			t.Errorf("%s: expected seen to be %t", test.name, test.seen)
+		}
+	}
+}
+
+func TestCoalescer(t *testing.T) {
+	delivered := make([]uint64, 0)
+
+	// flushed by hand, the period never passes during the test
+	c := newCoalescer(time.Hour, func(e *UserEvent) {
+		delivered = append(delivered, e.LTime)
+	})
+
+	for _, ltime := range []uint64{3, 5, 4} {
+		c.add(&UserEvent{LTime: ltime, Name: "deploy"})
+	}
+	c.add(&UserEvent{LTime: 1, Name: "restart"})
+
+	c.flush("deploy")
+	c.flush("restart")
+
+	// a late event older than the one delivered is dropped
+	c.add(&UserEvent{LTime: 4, Name: "deploy"})
+	c.flush("deploy")
+
+	c.add(&UserEvent{LTime: 7, Name: "deploy"})
+	c.flush("deploy")
+
+	expected := []uint64{5, 1, 7}
+	if len(delivered) != len(expected) {
+		t.Fatalf("expected %v to be delivered, found %v", expected, delivered)
+	}
+
+	for i := range expected {
+		if delivered[i] != expected[i] {
+			t.Errorf("expected %v to be delivered, found %v", expected, delivered)
+			break
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"log"
 	"os"
 	"time"
 
-	"github.com/zerklabs/auburn/log"
 	"github.com/zerklabs/busybody"
 	"github.com/zerklabs/busybody/protocol"
 )
@@ -25,19 +25,19 @@ log_level = 7
 func main() {
 	member1, err := busybody.New([]byte(testConfig))
 	if err != nil {
-		log.Error(err)
+		log.Println(err)
 		return
 	}
 
 	member1.AddHandler(busybody.HandlerFunc(func(m *protocol.Message) error {
 		body, err := m.Body()
 		if err != nil {
-			log.Error(err)
+			log.Println(err)
 
 			return err
 		}
 
-		log.Infof("member1: message received: %s", string(body))
+		log.Printf("member1: message received: %s", string(body))
 
 		return nil
 	}))
@@ -46,7 +46,7 @@ func main() {
 		os.Remove(member1.Uri())
 
 		if err := member1.Listen(); err != nil {
-			log.Error(err)
+			log.Println(err)
 		}
 	}()
 
@@ -56,7 +56,7 @@ func main() {
 
 	for i := 0; i < 5; i++ {
 		if err := member1.Send([]byte("hello from member1")); err != nil {
-			log.Error(err)
+			log.Println(err)
 		}
 	}
 
@@ -1,10 +1,10 @@
 package main
 
 import (
+	"log"
 	"os"
 	"sync"
 
-	"github.com/zerklabs/auburn/log"
 	"github.com/zerklabs/busybody"
 	"github.com/zerklabs/busybody/protocol"
 )
@@ -28,18 +28,18 @@ func main() {
 
 	member2, err := busybody.New([]byte(testConfig2))
 	if err != nil {
-		log.Error(err)
+		log.Println(err)
 	}
 
 	member2.AddHandler(busybody.HandlerFunc(func(m *protocol.Message) error {
 		body, err := m.Body()
 		if err != nil {
-			log.Error(err)
+			log.Println(err)
 
 			return err
 		}
 
-		log.Infof("member2: message received: %s", string(body))
+		log.Printf("member2: message received: %s", string(body))
 		wg.Done()
 
 		return nil
@@ -48,7 +48,7 @@ func main() {
 	go func() {
 		os.Remove(member2.Uri())
 		if err := member2.Listen(); err != nil {
-			log.Error(err)
+			log.Println(err)
 		}
 	}()
 
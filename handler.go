@@ -1,6 +1,11 @@
 package busybody
 
-import "github.com/zerklabs/busybody/protocol"
+import (
+	"context"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
 
 type Handler interface {
 	HandleMessage(msg *protocol.Message) error
@@ -11,3 +16,101 @@ type HandlerFunc func(msg *protocol.Message) error
 func (h HandlerFunc) HandleMessage(msg *protocol.Message) error {
 	return h(msg)
 }
+
+// Envelope describes the delivery of a message to a ContextHandler
+type Envelope struct {
+	Message  *protocol.Message
+	Sender   *Introduction // nil if the sender has not introduced itself yet
+	Received time.Time
+	Topic    string
+}
+
+// ContextHandler receives messages with a context which is cancelled when
+// the member is closed or the handler timeout expires
+type ContextHandler interface {
+	HandleMessageContext(ctx context.Context, env *Envelope) error
+}
+
+type ContextHandlerFunc func(ctx context.Context, env *Envelope) error
+
+func (h ContextHandlerFunc) HandleMessageContext(ctx context.Context, env *Envelope) error {
+	return h(ctx, env)
+}
+
+// AdaptHandler turns a Handler into a ContextHandler which ignores the
+// context and envelope
+func AdaptHandler(h Handler) ContextHandler {
+	return ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		return h.HandleMessage(env.Message)
+	})
+}
+
+// HandlerOption configures a handler registration
+type HandlerOption func(r *registration)
+
+// WithHandlerTimeout cancels the context passed to the handler after d
+func WithHandlerTimeout(d time.Duration) HandlerOption {
+	return func(r *registration) {
+		r.timeout = d
+	}
+}
+
+// WithHandlerQueue gives the handler its own queue of size deliveries and
+// its own worker, so a slow handler does not hold up the others. policy is
+// applied when the queue is full, unknown policies block.
+func WithHandlerQueue(size int, policy string) HandlerOption {
+	return func(r *registration) {
+		if validOverflowPolicy(policy) != nil {
+			policy = OverflowBlock
+		}
+
+		r.queueSize = size
+		r.policy = policy
+	}
+}
+
+// registration is a handler added to a member
+type registration struct {
+	handler    ContextHandler
+	timeout    time.Duration
+	queueSize  int
+	policy     string
+	queue      *dispatchQueue
+	middleware []Middleware
+	wrapped    ContextHandler
+}
+
+// newRegistration applies the options and wraps the handler in its own
+// middleware
+func newRegistration(handler ContextHandler, opts []HandlerOption) *registration {
+	r := &registration{handler: handler}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.wrapped = chain(r.handler, r.middleware)
+
+	return r
+}
+
+// Registration is the handle returned when a handler is added to a member
+type Registration struct {
+	member *BusyMember
+	r      *registration
+}
+
+// Remove unregisters the handler. Messages already being delivered to it may
+// still complete. It returns false if the handler was already removed.
+func (reg *Registration) Remove() bool {
+	return reg.member.removeHandler(reg.r)
+}
+
+type EventHandler interface {
+	HandleEvent(event *UserEvent) error
+}
+
+type EventHandlerFunc func(event *UserEvent) error
+
+func (h EventHandlerFunc) HandleEvent(event *UserEvent) error {
+	return h(event)
+}
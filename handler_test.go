@@ -0,0 +1,103 @@
+package busybody
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+func TestAdaptHandler(t *testing.T) {
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+
+	var got *protocol.Message
+	h := AdaptHandler(HandlerFunc(func(m *protocol.Message) error {
+		got = m
+		return nil
+	}))
+
+	if err := h.HandleMessageContext(context.Background(), &Envelope{Message: msg}); err != nil {
+		t.Error(err)
+	}
+
+	if got != msg {
+		t.Errorf("expected the adapted handler to receive the message")
+	}
+}
+
+func TestDispatchEnvelope(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	member.peers[0].Id = "peer"
+
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "peer")
+	msg.Header.Topic = "orders"
+
+	member.dispatch(msg)
+
+	env, ok := member.queue.pop()
+	if !ok {
+		t.Fatal("expected a queued delivery")
+	}
+
+	if env.Message != msg || env.Topic != "orders" {
+		t.Errorf("expected the message and its topic, found %q", env.Topic)
+	}
+
+	if env.Sender == nil || env.Sender.Uri != member.peers[0].Uri {
+		t.Errorf("expected the introduction of the sender, found %v", env.Sender)
+	}
+
+	member.dispatch(protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "stranger"))
+
+	if env, _ := member.queue.pop(); env.Sender != nil {
+		t.Errorf("expected no introduction for an unknown sender, found %v", env.Sender)
+	}
+}
+
+func TestHandlerContext(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+
+	env := &Envelope{Message: protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")}
+
+	// cancelled by the handler timeout
+	r := newRegistration(wait, []HandlerOption{WithHandlerTimeout(10 * time.Millisecond)})
+	if err := member.handle(r, env); err != context.DeadlineExceeded {
+		t.Errorf("expected the handler timeout to cancel the context, got %v", err)
+	}
+
+	// cancelled when the member is closed
+	done := make(chan error, 1)
+	go func() {
+		done <- member.handle(newRegistration(wait, nil), env)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	member.Close()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected closing the member to cancel the context, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected closing the member to cancel the context")
+	}
+}
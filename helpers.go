@@ -3,6 +3,8 @@ package busybody
 import (
 	"fmt"
 	"hash/crc32"
+	"math/rand"
+	"time"
 )
 
 func crc32hash(input string) string {
@@ -11,3 +13,18 @@ func crc32hash(input string) string {
 
 	return fmt.Sprintf("%x", crchash.Sum(nil))
 }
+
+// backoff returns the delay before retry attempt (starting at 0), doubling
+// from base up to max with up to half of the delay randomised as jitter
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	d := max
+	if attempt < 32 && base<<uint(attempt) < max {
+		d = base << uint(attempt)
+	}
+
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
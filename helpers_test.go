@@ -0,0 +1,21 @@
+package busybody
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		d := backoff(attempt, 100*time.Millisecond, 10*time.Second)
+
+		ceiling := 10 * time.Second
+		if attempt < 7 {
+			ceiling = (100 * time.Millisecond) << uint(attempt)
+		}
+
+		if d < ceiling/2 || d > ceiling {
+			t.Errorf("attempt %d: expected a delay between %s and %s, found %s", attempt, ceiling/2, ceiling, d)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package busybody
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retry delays used while contacting seeds, and how long a dial may take to
+// connect
+const (
+	joinBaseBackoff = 500 * time.Millisecond
+	joinMaxBackoff  = 30 * time.Second
+	dialTimeout     = 5 * time.Second
+)
+
+// Join contacts every seed in parallel, retrying each with capped exponential
+// backoff until it is reached, the redial attempts run out or ctx is done, and
+// returns how many seeds were reached. If none could be reached, Join keeps
+// retrying in the background until one contact succeeds or the member is
+// closed.
+func (m *BusyMember) Join(ctx context.Context, seeds ...string) (int, error) {
+	if len(seeds) == 0 {
+		return 0, fmt.Errorf("no seeds to join")
+	}
+
+	m.lock.RLock()
+	listening := m.listening
+	m.lock.RUnlock()
+
+	if !listening {
+		return 0, fmt.Errorf("member must be started before joining")
+	}
+
+	reached := m.joinSeeds(ctx, seeds, false)
+	if reached == 0 {
+		go m.retryJoin(seeds)
+		return 0, fmt.Errorf("unable to reach any of %d seeds, retrying in the background", len(seeds))
+	}
+
+	if err := m.hello(); err != nil {
+		m.log.Error("error sending introduction", "error", err)
+	}
+
+	return reached, nil
+}
+
+// retryJoin contacts the seeds in rounds until one of them is reached or the
+// member is closed
+func (m *BusyMember) retryJoin(seeds []string) {
+	for attempt := 0; m.joinSeeds(m.ctx, seeds, true) == 0; attempt++ {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(backoff(attempt, joinBaseBackoff, joinMaxBackoff)):
+		}
+	}
+
+	m.log.Info("joined the cluster after retrying")
+
+	if err := m.hello(); err != nil {
+		m.log.Error("error sending introduction", "error", err)
+	}
+}
+
+// joinSeeds dials every seed in parallel and returns how many were reached.
+// With first set the remaining attempts are abandoned after one succeeds.
+func (m *BusyMember) joinSeeds(ctx context.Context, seeds []string, first bool) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	reached := 0
+
+	for _, seed := range seeds {
+		wg.Add(1)
+
+		go func(seed string) {
+			defer wg.Done()
+
+			if !m.joinSeed(ctx, seed) {
+				return
+			}
+
+			lock.Lock()
+			reached += 1
+			lock.Unlock()
+
+			if first {
+				cancel()
+			}
+		}(seed)
+	}
+
+	wg.Wait()
+
+	return reached
+}
+
+// joinSeed dials a single seed with capped exponential backoff until it
+// succeeds, the redial attempts run out or ctx is done. A known peer which
+// could not be reached is marked as faulty.
+func (m *BusyMember) joinSeed(ctx context.Context, seed string) bool {
+	attempts := m.conf().RedialAttempts
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		err := m.dialSeed(ctx, seed)
+		if err == nil {
+			return true
+		}
+
+		m.log.Debug("error joining", "peer", seed, "attempt", attempt+1, "error", err)
+
+		if attempt+1 == attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff(attempt, joinBaseBackoff, m.conf().MaxRedialBackoff)):
+		}
+	}
+
+	m.setPeerConnection(strings.ToLower(seed), false, FaultyState)
+
+	return false
+}
+
+// dialSeed dials a seed, recording it as a peer, and waits until the port
+// hook reports the connection, dialTimeout passes or ctx is done
+func (m *BusyMember) dialSeed(ctx context.Context, seed string) error {
+	if seed == "" {
+		return fmt.Errorf("peer cannot be empty")
+	}
+
+	// make sure the stored uri is normalized
+	seed = strings.ToLower(seed)
+
+	if peer := m.peerByUri(seed); peer != nil && peer.connected {
+		return nil
+	}
+
+	if err := m.dial(seed); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	exists := false
+	for _, v := range m.peers {
+		if v.Uri == seed {
+			exists = true
+		}
+	}
+
+	if !exists {
+		m.peers = append(m.peers, Introduction{Key: m.conf().SharedKey, Uri: seed, state: HealthyState})
+	}
+	m.lock.Unlock()
+
+	if err := m.waitConnected(ctx, seed, dialTimeout); err != nil {
+		return err
+	}
+
+	m.log.Info("successfully connected", "peer", seed)
+
+	return nil
+}
+
+// waitConnected waits until the port hook reports a connection to the peer
+// at uri, timeout passes or ctx is done
+func (m *BusyMember) waitConnected(ctx context.Context, uri string, timeout time.Duration) error {
+	connected := make(chan struct{})
+
+	m.lock.Lock()
+	for _, peer := range m.peers {
+		if peer.Uri == uri && peer.connected {
+			m.lock.Unlock()
+			return nil
+		}
+	}
+
+	m.connectWaiters[uri] = append(m.connectWaiters[uri], connected)
+	m.lock.Unlock()
+
+	defer func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		waiters := m.connectWaiters[uri]
+		for i, ch := range waiters {
+			if ch == connected {
+				m.connectWaiters[uri] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(m.connectWaiters[uri]) == 0 {
+			delete(m.connectWaiters, uri)
+		}
+	}()
+
+	select {
+	case <-connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.StopChan:
+		return fmt.Errorf("member is closed")
+	case <-time.After(timeout):
+		return fmt.Errorf("no connection to %s within %s", uri, timeout)
+	}
+}
+
+// joinPeers dials every known peer in parallel. Peers which cannot be
+// reached yet are retried in the background until the redial attempts run
+// out.
+func (m *BusyMember) joinPeers() {
+	var wg sync.WaitGroup
+
+	for _, peer := range m.Members() {
+		if peer.connected {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(uri string) {
+			defer wg.Done()
+
+			if err := m.dialSeed(m.ctx, uri); err != nil {
+				m.log.Warn("unable to reach peer, retrying in the background", "peer", uri, "error", err)
+
+				go m.joinSeed(m.ctx, uri)
+			}
+		}(peer.Uri)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,33 @@
+package busybody
+
+import "sync/atomic"
+
+// LamportClock is a thread safe Lamport clock used to order user events
+// across the cluster
+type LamportClock struct {
+	counter uint64
+}
+
+// Time returns the current value of the clock
+func (l *LamportClock) Time() uint64 {
+	return atomic.LoadUint64(&l.counter)
+}
+
+// Increment advances the clock and returns the new value
+func (l *LamportClock) Increment() uint64 {
+	return atomic.AddUint64(&l.counter, 1)
+}
+
+// Witness moves the clock forward after seeing a time from another member
+func (l *LamportClock) Witness(v uint64) {
+	for {
+		cur := atomic.LoadUint64(&l.counter)
+		if v < cur {
+			return
+		}
+
+		if atomic.CompareAndSwapUint64(&l.counter, cur, v+1) {
+			return
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package busybody
+
+import "testing"
+
+func TestLamportClock(t *testing.T) {
+	var clock LamportClock
+
+	if clock.Time() != 0 {
+		t.Errorf("expected a new clock to start at 0")
+	}
+
+	if clock.Increment() != 1 {
+		t.Errorf("expected increment to return 1")
+	}
+
+	clock.Witness(41)
+	if clock.Time() != 42 {
+		t.Errorf("expected witness to move the clock past 41, found %d", clock.Time())
+	}
+
+	clock.Witness(10)
+	if clock.Time() != 42 {
+		t.Errorf("expected witnessing an older time to be ignored, found %d", clock.Time())
+	}
+}
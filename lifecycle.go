@@ -0,0 +1,280 @@
+package busybody
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// Start listens on the configured uri, connects to the known peers and
+// introduces this member to them. It returns once the member has joined, or
+// with the context error if ctx is done first, in which case the member is
+// closed.
+func (m *BusyMember) Start(ctx context.Context) error {
+	m.lock.Lock()
+	if m.listening {
+		m.lock.Unlock()
+		return fmt.Errorf("member already started")
+	}
+
+	if m.terminate {
+		m.lock.Unlock()
+		return fmt.Errorf("member is closed")
+	}
+
+	// start our listener
+	if err := m.bussock.Listen(m.conf().Uri); err != nil {
+		m.lock.Unlock()
+		return err
+	}
+
+	m.listening = true
+	m.lock.Unlock()
+
+	joined := make(chan struct{})
+	go func() {
+		m.joinPeers()
+		close(joined)
+	}()
+
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		m.finish(ctx.Err())
+		return ctx.Err()
+	}
+
+	// the workers are counted under the lock so Shutdown never waits on the
+	// group while they are being added
+	m.lock.Lock()
+	if m.draining || m.terminate {
+		m.lock.Unlock()
+		return fmt.Errorf("member is closed")
+	}
+
+	workers := m.conf().HandlerWorkers
+	m.workers.Add(workers)
+	m.lock.Unlock()
+
+	// start dealing with incoming messages
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+
+	go m.handlerLoop()
+	go m.controlLoop()
+	go m.notificationLoop()
+	go m.receiveLoop()
+
+	if err := m.hello(); err != nil {
+		m.log.Error("error sending introduction", "error", err)
+	}
+
+	return nil
+}
+
+// Shutdown leaves the cluster and stops the member. The leave is sent and
+// messages already queued for handlers are delivered until ctx is done, after
+// which the member is closed regardless and the context error returned.
+func (m *BusyMember) Shutdown(ctx context.Context) error {
+	// sending the leave may block on a busy socket, so it only gets until the
+	// deadline. finish unblocks it if it gives up.
+	left := make(chan error, 1)
+	go func() {
+		left <- m.leave()
+	}()
+
+	select {
+	case err := <-left:
+		if err != nil {
+			m.log.Error("error sending leave", "error", err)
+		}
+	case <-ctx.Done():
+		m.log.Warn("gave up sending leave", "error", ctx.Err())
+	}
+
+	// stop taking new deliveries and let the workers drain the queues. No
+	// workers are added once draining is set, so waiting on them is safe.
+	m.queue.close()
+
+	m.lock.Lock()
+	m.draining = true
+	for _, r := range m.handlers {
+		if r.queue != nil {
+			r.queue.close()
+		}
+	}
+	m.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(drained)
+	}()
+
+	var err error
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	m.finish(nil)
+
+	return err
+}
+
+// Done is closed once the member has stopped
+func (m *BusyMember) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err returns the error which stopped the member, or nil if it was closed or
+// shut down normally
+func (m *BusyMember) Err() error {
+	<-m.done
+	return m.doneErr
+}
+
+// Close stops the member immediately, without leaving the cluster or waiting
+// for handlers. It is safe to call more than once.
+func (m *BusyMember) Close() error {
+	m.finish(nil)
+	return nil
+}
+
+// finish stops every loop and closes the socket, recording err as the reason
+func (m *BusyMember) finish(err error) {
+	m.closeOnce.Do(func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		m.swimTicker.Stop()
+		m.eventTicker.Stop()
+		m.reconnectTicker.Stop()
+		m.terminate = true
+		m.cancel()
+		close(m.StopChan)
+
+		m.queue.close()
+		for _, r := range m.handlers {
+			if r.queue != nil {
+				r.queue.close()
+			}
+		}
+
+		if cerr := m.bussock.Close(); cerr != nil && err == nil {
+			m.log.Error("error closing socket", "error", cerr)
+		}
+
+		m.doneErr = err
+		close(m.done)
+	})
+}
+
+// stopped reports whether the member has been closed
+func (m *BusyMember) stopped() bool {
+	select {
+	case <-m.StopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// receiveLoop reads frames from the bus until the member is closed
+func (m *BusyMember) receiveLoop() {
+	for {
+		msg, err := m.bussock.Recv()
+		if err != nil {
+			if !m.stopped() {
+				m.finish(err)
+			}
+
+			return
+		}
+
+		bmsg, err := protocol.Decode(msg)
+		if err != nil {
+			m.log.Error("error decoding message", "error", err)
+			m.metrics.Counter(MetricDecodeErrors, 1, "type", "frame")
+			continue
+		}
+
+		// we ignore messages from ourselves
+		if bmsg.Sender() == m.id {
+			continue
+		}
+
+		m.recordReceived(bmsg)
+
+		if frag := bmsg; frag.Fragmented() {
+			if bmsg, err = m.reassembler.Add(frag, m.clock.Now()); err != nil {
+				m.log.Error("error reassembling message", "peer_id", frag.Sender(), "message_id", frag.MessageId(), "error", err)
+				continue
+			}
+
+			// still waiting on other fragments
+			if bmsg == nil {
+				continue
+			}
+		}
+
+		m.route(bmsg)
+	}
+}
+
+func (m *BusyMember) leavemsg() *protocol.Message {
+	msg := m.hellomsg()
+	msg.Header.MsgType = protocol.LeaveMessage
+
+	return msg
+}
+
+// leave tells the other members this one is leaving the cluster
+func (m *BusyMember) leave() error {
+	m.lock.RLock()
+	listening := m.listening
+	m.lock.RUnlock()
+
+	if !listening || m.stopped() {
+		return nil
+	}
+
+	return m.sendLeave(m.Introduction())
+}
+
+// sendLeave gossips that the member described by intro has left
+func (m *BusyMember) sendLeave(intro *Introduction) error {
+	buffer := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buffer).Encode(intro); err != nil {
+		return fmt.Errorf("error gob encoding message: %v", err)
+	}
+
+	msg := m.leavemsg()
+	if _, err := msg.Write(buffer.Bytes()); err != nil {
+		return fmt.Errorf("error writing content to message: %v", err)
+	}
+
+	return m.send(msg)
+}
+
+// peerLeft marks the member described by intro as having left
+func (m *BusyMember) peerLeft(intro *Introduction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.peers {
+		if m.peers[i].Id == intro.Id {
+			m.peers[i].setState(LeftState, m.clock.Now())
+			m.peers[i].connected = false
+
+			m.log.Info("peer left", "peer_id", intro.Id, "peer", intro.Uri)
+			m.recordMembership(MemberLeft, intro.Id, intro.Uri)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package busybody
+
+import (
+	"time"
+)
+
+// Levels for the log_level setting, following the syslog severities. Errors
+// are always logged; log_level decides whether warnings, informational and
+// debug output are passed on to the Logger. The default of 0 logs errors
+// only.
+const (
+	LogLevelError = 3
+	LogLevelWarn  = 4
+	LogLevelInfo  = 6
+	LogLevelDebug = 7
+)
+
+// Logger receives the log output of a member. Each call carries a message
+// and alternating key and value pairs, e.g. "peer", uri.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+
+	// With returns a Logger which adds keyvals to every call
+	With(keyvals ...interface{}) Logger
+}
+
+// NopLogger discards everything. It is used when no Logger is configured.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (NopLogger) Info(msg string, keyvals ...interface{})  {}
+func (NopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (NopLogger) Error(msg string, keyvals ...interface{}) {}
+func (n NopLogger) With(keyvals ...interface{}) Logger     { return n }
+
+// levelFilter drops output below the log level returned by level
+type levelFilter struct {
+	level func() int
+	next  Logger
+}
+
+func (f levelFilter) Debug(msg string, keyvals ...interface{}) {
+	if f.level() >= LogLevelDebug {
+		f.next.Debug(msg, keyvals...)
+	}
+}
+
+func (f levelFilter) Info(msg string, keyvals ...interface{}) {
+	if f.level() >= LogLevelInfo {
+		f.next.Info(msg, keyvals...)
+	}
+}
+
+func (f levelFilter) Warn(msg string, keyvals ...interface{}) {
+	if f.level() >= LogLevelWarn {
+		f.next.Warn(msg, keyvals...)
+	}
+}
+
+func (f levelFilter) Error(msg string, keyvals ...interface{}) {
+	f.next.Error(msg, keyvals...)
+}
+
+func (f levelFilter) With(keyvals ...interface{}) Logger {
+	return levelFilter{level: f.level, next: f.next.With(keyvals...)}
+}
+
+// newLogger wraps l so every call is tagged with the member id and filtered
+// by the current log_level
+func (m *BusyMember) newLogger(l Logger) Logger {
+	if l == nil {
+		l = NopLogger{}
+	}
+
+	return levelFilter{
+		level: func() int { return m.conf().LogLevel },
+		next:  l.With("member", m.id),
+	}
+}
+
+// Clock is the source of time used for message expiry, fragment reassembly
+// and reaping tombstones
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock reads the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,89 @@
+package busybody
+
+import (
+	"testing"
+)
+
+// recordLogger remembers the messages it receives
+type recordLogger struct {
+	fields  []interface{}
+	entries *[]string
+}
+
+func (r recordLogger) Debug(msg string, keyvals ...interface{}) { *r.entries = append(*r.entries, msg) }
+func (r recordLogger) Info(msg string, keyvals ...interface{})  { *r.entries = append(*r.entries, msg) }
+func (r recordLogger) Warn(msg string, keyvals ...interface{})  { *r.entries = append(*r.entries, msg) }
+func (r recordLogger) Error(msg string, keyvals ...interface{}) { *r.entries = append(*r.entries, msg) }
+
+func (r recordLogger) With(keyvals ...interface{}) Logger {
+	return recordLogger{fields: append(r.fields, keyvals...), entries: r.entries}
+}
+
+func TestLevelFilter(t *testing.T) {
+	entries := make([]string, 0)
+	level := LogLevelWarn
+
+	l := levelFilter{level: func() int { return level }, next: recordLogger{entries: &entries}}
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	if len(entries) != 2 || entries[0] != "warn" || entries[1] != "error" {
+		t.Errorf("expected warn and error to pass at the warn level, got %v", entries)
+	}
+
+	level = LogLevelDebug
+	l.With("peer", "ipc:///tmp/ipc1.ipc").Debug("debug")
+
+	if len(entries) != 3 {
+		t.Errorf("expected debug to pass once the level is raised, got %v", entries)
+	}
+}
+
+func TestMemberLoggerFields(t *testing.T) {
+	entries := make([]string, 0)
+	rec := recordLogger{entries: &entries}
+
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	WithLogger(rec)(member)
+
+	f, ok := member.log.(levelFilter)
+	if !ok {
+		t.Fatalf("expected the member logger to filter by level")
+	}
+
+	fields := f.next.(recordLogger).fields
+	if len(fields) != 2 || fields[0] != "member" || fields[1] != member.id {
+		t.Errorf("expected the member id as a field, got %v", fields)
+	}
+}
+
+func TestCausalLogger(t *testing.T) {
+	entries := make([]string, 0)
+
+	conf, err := ParseConfig([]byte(testConfig + "causal_ordering = true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := NewWithConfig(conf, WithLogger(recordLogger{entries: &entries}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	f, ok := member.causal.log.(levelFilter)
+	if !ok {
+		t.Fatalf("expected the causal queue to log through the member logger")
+	}
+
+	if _, ok := f.next.(recordLogger); !ok {
+		t.Errorf("expected the causal queue to log through WithLogger, got %T", f.next)
+	}
+}
@@ -4,14 +4,15 @@ import (
 	// "github.com/gdamore/mangos/transport/ipc"
 	// "github.com/gdamore/mangos/transport/tlstcp"
 
+	"context"
 	"fmt"
 	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gdamore/mangos"
-	"github.com/zerklabs/auburn/log"
 	"github.com/zerklabs/busybody/protocol"
 )
 
@@ -21,35 +22,88 @@ type BusyMember struct {
 	lock             sync.RWMutex
 	bussock          mangos.Socket
 	config           *BusyConfig
+	configLock       sync.RWMutex
+	reloadLock       sync.Mutex
+	log              Logger
+	clock            Clock
+	metrics          Metrics
+	membership       *membershipLog
+	monitors         monitors
 	id               string
 	hostname         string
 	peers            []Introduction
 	terminate        bool
-	handlers         []Handler
+	handlers         []*registration
+	queue            *dispatchQueue
+	middleware       []Middleware
+	ctx              context.Context
+	cancel           context.CancelFunc
+	causal           *causalQueue
+	eventClock       LamportClock
+	eventSeen        *eventBuffer
+	eventLock        sync.Mutex
+	eventQueue       []*queuedEvent
+	eventHandlers    []EventHandler
+	eventTicker      *time.Ticker
+	reconnectTicker  *time.Ticker
+	coalescer        *coalescer
+	stats            stats
+	reassembler      *protocol.Reassembler
+	redialing        map[string]bool
+	connectWaiters   map[string][]chan struct{}
+	dialers          map[string]mangos.Dialer
+	dialLock         sync.Mutex
 	incomingMessages chan *protocol.Message
+	controlMessages  chan *protocol.Message
+	outgoingMessages chan *outgoing
+	controlOutgoing  chan *outgoing
 	StopChan         chan int
+	done             chan struct{}
+	doneErr          error
+	closeOnce        sync.Once
+	workers          sync.WaitGroup
 	swimTicker       *time.Ticker
 	swimTimeout      *time.Timer
 	swimWaitGroup    sync.WaitGroup
 	polling          bool
 	listening        bool
+	draining         bool
 }
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// New creates a member from a TOML configuration document
 func New(config []byte) (*BusyMember, error) {
-	bussock, err := newBusSocket(make(map[string]interface{}, 0))
+	if len(config) == 0 {
+		return nil, fmt.Errorf("configuration missing")
+	}
+
+	conf, err := ParseConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(config) == 0 {
+	return NewWithConfig(conf)
+}
+
+// NewWithConfig creates a member from a configuration built in code. The
+// configuration is validated and copied, so later changes to config have no
+// effect on the member.
+func NewWithConfig(config *BusyConfig, opts ...Option) (*BusyMember, error) {
+	if config == nil {
 		return nil, fmt.Errorf("configuration missing")
 	}
 
-	conf, err := ParseConfig(config)
+	conf := *config
+	conf.Peers = append([]string(nil), config.Peers...)
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	bussock, err := newBusSocket(make(map[string]interface{}, 0))
 	if err != nil {
 		return nil, err
 	}
@@ -58,18 +112,50 @@ func New(config []byte) (*BusyMember, error) {
 		hostname:         hostname,
 		id:               crc32hash(hostname),
 		bussock:          bussock,
-		config:           conf,
+		config:           &conf,
+		clock:            systemClock{},
+		metrics:          NewExpvarMetrics("busybody"),
+		membership:       newMembershipLog(membershipLogSize),
 		terminate:        false,
 		listening:        false,
 		swimTicker:       time.NewTicker(conf.SwimInterval),
 		swimTimeout:      time.NewTimer(conf.SwimTimeout),
 		peers:            make([]Introduction, 0),
-		incomingMessages: make(chan *protocol.Message),
+		incomingMessages: make(chan *protocol.Message, applicationQueueSize),
+		controlMessages:  make(chan *protocol.Message, controlQueueSize),
+		outgoingMessages: make(chan *outgoing, applicationQueueSize),
+		controlOutgoing:  make(chan *outgoing, controlQueueSize),
 		StopChan:         make(chan int),
-		handlers:         make([]Handler, 0),
+		done:             make(chan struct{}),
+		handlers:         make([]*registration, 0),
+		middleware:       make([]Middleware, 0),
+		eventSeen:        newEventBuffer(conf.EventBufferSize),
+		eventQueue:       make([]*queuedEvent, 0),
+		eventHandlers:    make([]EventHandler, 0),
+		eventTicker:      time.NewTicker(eventGossipInterval),
+		reconnectTicker:  time.NewTicker(conf.ReconnectInterval),
+		reassembler:      protocol.NewReassembler(conf.FragmentTimeout, conf.FragmentMemoryLimit),
+		redialing:        make(map[string]bool),
+		connectWaiters:   make(map[string][]chan struct{}),
+		dialers:          make(map[string]mangos.Dialer),
 		polling:          false,
 	}
 
+	member.log = member.newLogger(conf.Logger)
+	member.ctx, member.cancel = context.WithCancel(context.Background())
+	member.bussock.SetPortHook(member.portHook)
+	member.queue = newDispatchQueue(conf.HandlerQueueSize, conf.OverflowPolicy, &member.stats.droppedMessages)
+	member.coalescer = newCoalescer(conf.EventCoalescePeriod, member.deliverEvent)
+
+	for _, opt := range opts {
+		opt(member)
+	}
+
+	// built after the options so it logs through WithLogger
+	if conf.CausalOrdering {
+		member.causal = newCausalQueue(member.id, member.log)
+	}
+
 	for _, v := range member.config.Peers {
 		if err := member.AddPeer(v); err != nil {
 			return nil, err
@@ -79,35 +165,30 @@ func New(config []byte) (*BusyMember, error) {
 	// stop the timeout ticker
 	member.swimTimeout.Stop()
 
+	go member.sendLoop()
+
 	return member, nil
 }
 
+// conf returns the current configuration, which Reload may replace
+func (m *BusyMember) conf() *BusyConfig {
+	m.configLock.RLock()
+	defer m.configLock.RUnlock()
+
+	return m.config
+}
+
 func (m *BusyMember) Uri() string {
-	return m.config.Uri
+	return m.conf().Uri
 }
 
 // Generates an introduction message for this node
 func (m *BusyMember) Introduction() *Introduction {
 	return &Introduction{
-		Key: m.config.SharedKey,
+		Key: m.conf().SharedKey,
 		Id:  m.id,
-		Uri: m.config.Uri,
-	}
-}
-
-func (m *BusyMember) connectToPeers() error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	for _, peer := range m.peers {
-		if !peer.connected {
-			if err := m.DialBus(&peer); err != nil {
-				return err
-			}
-		}
+		Uri: m.conf().Uri,
 	}
-
-	return nil
 }
 
 func (m *BusyMember) AddPeer(peer string) error {
@@ -131,20 +212,16 @@ func (m *BusyMember) AddPeer(peer string) error {
 	}
 
 	if !exists {
-		intro := Introduction{Key: m.config.SharedKey, Uri: peer, connected: false, state: HealthyState}
+		intro := Introduction{Key: m.conf().SharedKey, Uri: peer, connected: false, state: HealthyState}
 
 		if m.listening {
-			if err := m.bussock.Dial(peer); err != nil {
+			if err := m.dial(peer); err != nil {
 				return err
 			}
 
-			// flag the connection state
-			intro.connected = true
 			// pause for join
 			time.Sleep(time.Second)
-			if m.config.LogLevel >= log.INFO {
-				log.Infof("successfully connected to: %s", peer)
-			}
+			m.log.Info("dialing peer", "peer", peer)
 		}
 
 		m.peers = append(m.peers, intro)
@@ -153,25 +230,71 @@ func (m *BusyMember) AddPeer(peer string) error {
 	return nil
 }
 
+// Members returns a copy of this members view of its peers, including their
+// connection state
 func (m *BusyMember) Members() []Introduction {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	return m.peers
+	peers := make([]Introduction, len(m.peers))
+	copy(peers, m.peers)
+
+	return peers
+}
+
+// RemovePeer drops the peer with the given id or uri from this members view
+// of the cluster and stops dialing it. Other members are not told; a live
+// peer will be added back when it next introduces itself.
+func (m *BusyMember) RemovePeer(peer string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	uri := strings.ToLower(peer)
+
+	for i := range m.peers {
+		if m.peers[i].Uri == uri || (m.peers[i].Id != "" && m.peers[i].Id == peer) {
+			m.log.Info("removed peer", "peer_id", m.peers[i].Id, "peer", m.peers[i].Uri)
+			m.recordMembership(MemberRemoved, m.peers[i].Id, m.peers[i].Uri)
+			m.closeDialer(m.peers[i].Uri)
+
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown peer: %s", peer)
+}
+
+// ForceLeave marks the failed member with the given id as left and gossips
+// that to the cluster, so every member stops treating it as a peer
+func (m *BusyMember) ForceLeave(id string) error {
+	if id == m.id {
+		return fmt.Errorf("cannot force this member to leave, use Shutdown")
+	}
+
+	intro := m.peerById(id)
+	if intro == nil {
+		return fmt.Errorf("unknown peer: %s", id)
+	}
+
+	if intro.state == HealthyState && intro.connected {
+		return fmt.Errorf("peer %s is healthy", id)
+	}
+
+	intro.Key = m.conf().SharedKey
+	m.peerLeft(intro)
+
+	return m.sendLeave(intro)
 }
 
 func (m *BusyMember) DialBus(p *Introduction) error {
-	if err := m.bussock.Dial(p.Uri); err != nil {
+	if err := m.dial(p.Uri); err != nil {
 		return err
 	}
 
 	// pause for join
 	time.Sleep(time.Second)
-	if m.config.LogLevel >= log.INFO {
-		log.Infof("added and successfully connected to: %s (%s)", p.Id, p.Uri)
-	}
-
-	p.connected = true
+	m.log.Info("added and dialing", "peer_id", p.Id, "peer", p.Uri)
 
 	return nil
 }
@@ -230,7 +353,10 @@ func (m *BusyMember) selectPeerGroup(target *Introduction) []*Introduction {
 	return group
 }
 
-func (m *BusyMember) updatePeer(intro *Introduction) error {
+// updatePeer records an introduction. direct is set when the introduction
+// came from the member itself rather than being gossiped by another member;
+// only a direct introduction can bring back a faulty or left member.
+func (m *BusyMember) updatePeer(intro *Introduction, direct bool) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -240,8 +366,20 @@ func (m *BusyMember) updatePeer(intro *Introduction) error {
 	}
 
 	for i, v := range m.peers {
-		if v.Uri == intro.Uri {
+		if v.Uri == intro.Uri || (v.Id != "" && v.Id == intro.Id) {
 			exists = true
+
+			if v.state == FaultyState || v.state == LeftState {
+				if !direct {
+					continue
+				}
+
+				m.peers[i].setState(HealthyState, m.clock.Now())
+
+				m.log.Info("peer rejoined", "peer_id", intro.Id, "peer", intro.Uri)
+				m.recordMembership(MemberRejoined, intro.Id, intro.Uri)
+			}
+
 			if v.Id == "" {
 
 				if !m.peers[i].connected {
@@ -249,13 +387,13 @@ func (m *BusyMember) updatePeer(intro *Introduction) error {
 						return err
 					}
 				}
+				connected := m.peers[i].connected
 				m.peers[i] = *intro
-				m.peers[i].connected = true
-				m.peers[i].state = HealthyState
+				m.peers[i].connected = connected
+				m.peers[i].setState(HealthyState, m.clock.Now())
 
-				if m.config.LogLevel >= log.INFO {
-					log.Infof("updated peer: %#v", intro)
-				}
+				m.log.Info("updated peer", "peer_id", intro.Id, "peer", intro.Uri)
+				m.recordMembership(MemberUpdated, intro.Id, intro.Uri)
 			}
 		}
 	}
@@ -266,141 +404,308 @@ func (m *BusyMember) updatePeer(intro *Introduction) error {
 		}
 
 		intro.state = HealthyState
-		intro.connected = true
 
 		m.peers = append(m.peers, *intro)
+		m.recordMembership(MemberJoined, intro.Id, intro.Uri)
 	}
 
 	return nil
 }
 
-func (m *BusyMember) Close() error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-
-	m.swimTicker.Stop()
-	m.terminate = true
-	close(m.StopChan)
-	close(m.incomingMessages)
-
-	return nil
-}
-
 func (m *BusyMember) notificationLoop() {
 	for {
 		select {
+		case <-m.StopChan:
+			return
+		case <-m.eventTicker.C:
+			m.gossipEvents()
+		case <-m.reconnectTicker.C:
+			m.reconnectFailed()
+			m.reap(m.clock.Now())
+			m.recordMembers()
 		case <-m.swimTimeout.C:
 			if m.polling {
 				m.polling = false
 			}
 		case <-m.swimTicker.C:
-			m.swimTimeout.Reset(m.config.SwimTimeout)
+			m.swimTimeout.Reset(m.conf().SwimTimeout)
 
 			for {
 				if err := m.hello(); err != nil {
-					log.Error(err)
+					m.log.Error("error sending introduction", "error", err)
 				}
 
 				if err := m.share(); err != nil {
-					log.Error(err)
+					m.log.Error("error sharing peers", "error", err)
 				}
 
+				m.recordMembers()
+
 				break
 			}
 		}
 	}
 }
 
+// handlerLoop serves application traffic: standard messages are dispatched
+// to the handler workers and user events delivered. It may block on a full
+// dispatch queue, so membership traffic is served by controlLoop instead.
 func (m *BusyMember) handlerLoop() {
+	// overdue causal predecessors are given up on by the handler loop itself,
+	// so delivery stays in causal order
+	var expire <-chan time.Time
+	if m.causal != nil {
+		ticker := time.NewTicker(causalCheckInterval)
+		defer ticker.Stop()
+
+		expire = ticker.C
+	}
+
 	for {
-		message, ok := <-m.incomingMessages
-		if !ok {
-			goto exit
+		var message *protocol.Message
+
+		select {
+		case <-m.StopChan:
+			m.log.Info("stopping handler")
+			return
+		case <-expire:
+			for _, ready := range m.causal.expire(m.clock.Now()) {
+				m.dispatch(ready)
+			}
+
+			continue
+		case message = <-m.incomingMessages:
 		}
 
 		if message.MessageType() == protocol.StandardMessage {
-			for _, handler := range m.handlers {
-				if err := handler.HandleMessage(message); err != nil {
-					log.Errorf("error during HandleMessage: %v", err)
-				}
+			if m.causal == nil {
+				m.dispatch(message)
+				continue
+			}
+
+			for _, ready := range m.causal.receive(message, m.clock.Now()) {
+				m.dispatch(ready)
 			}
 		}
 
+		if message.MessageType() == protocol.UserEventMessage {
+			event, err := UnmarshalUserEvent(message)
+			if err != nil {
+				m.log.Error("error decoding event", "peer_id", message.Sender(), "error", err)
+				m.metrics.Counter(MetricDecodeErrors, 1, "type", "user_event")
+				continue
+			}
+
+			m.receiveEvent(event)
+		}
+	}
+}
+
+// controlLoop serves membership traffic on its own, so slow handlers never
+// hold up introductions and leaves
+func (m *BusyMember) controlLoop() {
+	for {
+		var message *protocol.Message
+
+		select {
+		case <-m.StopChan:
+			return
+		case message = <-m.controlMessages:
+		}
+
+		if message.MessageType() == protocol.LeaveMessage {
+			intro, err := UnmarshalIntroduction(message)
+			if err != nil {
+				m.log.Error("error decoding introduction", "peer_id", message.Sender(), "type", message.MessageType(), "error", err)
+				m.metrics.Counter(MetricDecodeErrors, 1, "type", protocol.MessageTypeName(message.MessageType()))
+				continue
+			}
+
+			if intro.Key != m.conf().SharedKey {
+				m.log.Warn("received unauthorized leave", "peer_id", intro.Id, "peer", intro.Uri)
+				m.metrics.Counter(MetricUnauthorized, 1, "type", "leave")
+				continue
+			}
+
+			m.peerLeft(intro)
+			m.recordMembers()
+		}
+
 		if message.MessageType() == protocol.HelloMessage {
 			intro, err := UnmarshalIntroduction(message)
 			if err != nil {
-				log.Error(err)
+				m.log.Error("error decoding introduction", "peer_id", message.Sender(), "type", message.MessageType(), "error", err)
+				m.metrics.Counter(MetricDecodeErrors, 1, "type", protocol.MessageTypeName(message.MessageType()))
 				continue
 			}
 
-			if intro.Key != m.config.SharedKey {
-				if m.config.LogLevel >= log.WARN {
-					log.Warnf("received unauthorized introduction: %#v", intro)
-				}
+			if intro.Key != m.conf().SharedKey {
+				m.log.Warn("received unauthorized introduction", "peer_id", intro.Id, "peer", intro.Uri)
+				m.metrics.Counter(MetricUnauthorized, 1, "type", "hello")
 				continue
 			}
 
-			if err := m.updatePeer(intro); err != nil {
-				log.Error(err)
+			if intro.Id == message.Sender() {
+				m.recordHello(message)
+			}
+
+			if err := m.updatePeer(intro, intro.Id == message.Sender()); err != nil {
+				m.log.Error("error updating peer", "peer_id", intro.Id, "peer", intro.Uri, "error", err)
 			}
+
+			m.recordMembers()
 		}
 	}
-
-exit:
-	log.Infof("stopping handler")
-	m.Close()
 }
 
-func (m *BusyMember) AddHandler(handler Handler) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// dispatch queues a message for the handler workers
+func (m *BusyMember) dispatch(message *protocol.Message) {
+	env := &Envelope{
+		Message:  message,
+		Sender:   m.peerById(message.Sender()),
+		Received: message.Received(),
+		Topic:    message.Topic(),
+	}
 
-	m.handlers = append(m.handlers, handler)
+	m.queue.push(env)
 }
 
-func (m *BusyMember) Listen() error {
-	var msg []byte
-	var err error
+// deliver passes a delivery to every registered handler, dropping it if its
+// deadline has already passed
+func (m *BusyMember) deliver(env *Envelope) {
+	if env.Message.Expired(m.clock.Now()) {
+		atomic.AddUint64(&m.stats.expiredMessages, 1)
+		m.metrics.Counter(MetricMessagesExpired, 1)
+		m.log.Debug("dropping expired message", "peer_id", env.Message.Sender(), "type", env.Message.MessageType())
 
-	defer m.Close()
+		return
+	}
 
-	// start our listener
-	if err := m.bussock.Listen(m.config.Uri); err != nil {
-		return err
+	m.publish(func() MonitorEntry {
+		body, _ := env.Message.Body()
+		return MonitorEntry{Kind: MonitorKindMessage, Message: &MonitorMessage{Sender: env.Message.Sender(), Topic: env.Topic, Body: body}}
+	})
+
+	m.lock.RLock()
+	handlers := m.handlers
+	m.lock.RUnlock()
+
+	for _, r := range handlers {
+		// handlers with their own queue are served by their own worker
+		if r.queue != nil {
+			r.queue.push(env)
+			continue
+		}
+
+		if err := m.handle(r, env); err != nil {
+			m.log.Error("error during HandleMessage", "peer_id", env.Message.Sender(), "topic", env.Topic, "error", err)
+		}
 	}
+}
 
-	m.listening = true
+// handle calls a single handler with a context derived from the members
+func (m *BusyMember) handle(r *registration, env *Envelope) error {
+	ctx := m.ctx
 
-	if err := m.connectToPeers(); err != nil {
-		return err
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
 	}
 
-	time.After(time.Second * 2)
+	m.lock.RLock()
+	mw := m.middleware
+	m.lock.RUnlock()
 
-	// start dealing with incoming messages
-	go m.handlerLoop()
-	go m.notificationLoop()
+	// a panicking handler must never take down the member
+	h := chain(r.wrapped, append([]Middleware{Recovery()}, mw...))
 
-	for {
-		if m.terminate {
-			return nil
-		}
+	start := time.Now()
+	err := h.HandleMessageContext(ctx, env)
+	m.recordHandler(start, err)
 
-		if msg, err = m.bussock.Recv(); err != nil {
-			return err
-		}
+	return err
+}
 
-		bmsg, err := protocol.Decode(msg)
-		if err != nil {
-			log.Errorf("error decoding message: %v", err)
-			continue
-		}
+// peerById returns a copy of the introduction of the peer with the given id
+func (m *BusyMember) peerById(id string) *Introduction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-		// we ignore messages from ourselves
-		if bmsg.Sender() != m.id {
-			m.incomingMessages <- bmsg
+	for _, peer := range m.peers {
+		if peer.Id == id {
+			intro := peer
+			return &intro
 		}
 	}
 
 	return nil
 }
+
+// AddHandler registers a handler for StandardMessages. The returned
+// registration can be used to remove it again.
+func (m *BusyMember) AddHandler(handler Handler) *Registration {
+	return m.AddContextHandler(AdaptHandler(handler))
+}
+
+// AddContextHandler registers a handler which receives a context, cancelled
+// when the member is closed, and the delivery envelope of each message
+func (m *BusyMember) AddContextHandler(handler ContextHandler, opts ...HandlerOption) *Registration {
+	r := newRegistration(handler, opts)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// a member which is shutting down serves the handler inline instead, as
+	// its workers must not be added to while Shutdown waits on them
+	if r.queueSize > 0 && !m.draining && !m.terminate {
+		r.queue = newDispatchQueue(r.queueSize, r.policy, &m.stats.droppedHandlerMessages)
+		m.workers.Add(1)
+		go m.handlerWorker(r)
+	}
+
+	// handlers is copied on write so dispatch can work from a snapshot
+	handlers := make([]*registration, 0, len(m.handlers)+1)
+	handlers = append(handlers, m.handlers...)
+	m.handlers = append(handlers, r)
+
+	return &Registration{member: m, r: r}
+}
+
+// removeHandler unregisters r, returning false if it was not registered
+func (m *BusyMember) removeHandler(r *registration) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	handlers := make([]*registration, 0, len(m.handlers))
+	for _, v := range m.handlers {
+		if v != r {
+			handlers = append(handlers, v)
+		}
+	}
+
+	if len(handlers) == len(m.handlers) {
+		return false
+	}
+
+	m.handlers = handlers
+
+	if r.queue != nil {
+		r.queue.close()
+	}
+
+	return true
+}
+
+// Listen starts the member and blocks until it stops, returning the error
+// which stopped it
+func (m *BusyMember) Listen() error {
+	if err := m.Start(context.Background()); err != nil {
+		m.Close()
+		return err
+	}
+
+	<-m.Done()
+
+	return m.Err()
+}
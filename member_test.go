@@ -1,10 +1,15 @@
 package busybody
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gdamore/mangos"
 	"github.com/zerklabs/busybody/protocol"
 )
 
@@ -43,6 +48,43 @@ func TestNew(t *testing.T) {
 	t.Logf("%#v", member)
 }
 
+func TestNewWithConfig(t *testing.T) {
+	conf := &BusyConfig{
+		Uri:               "ipc:///tmp/ipc0.ipc",
+		Peers:             []string{"ipc:///tmp/ipc1.ipc"},
+		SharedKey:         "default_shared_key",
+		ReconnectInterval: 10 * time.Second,
+	}
+
+	member, err := NewWithConfig(conf, WithHandler(HandlerFunc(func(msg *protocol.Message) error {
+		return nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	if member.config.ReconnectInterval != 10*time.Second {
+		t.Errorf("expected the reconnect interval set in code to be kept, got %s", member.config.ReconnectInterval)
+	}
+
+	if member.config.Codec != DefaultCodec {
+		t.Errorf("expected the default codec, got %q", member.config.Codec)
+	}
+
+	if conf.Codec != "" {
+		t.Errorf("expected the caller's config to be left untouched")
+	}
+
+	if len(member.handlers) != 1 {
+		t.Errorf("expected 1 handler, got %d", len(member.handlers))
+	}
+
+	if _, err := NewWithConfig(&BusyConfig{}); err == nil {
+		t.Errorf("expected a config without a uri to be rejected")
+	}
+}
+
 func TestRandomPeer(t *testing.T) {
 	member, err := New([]byte(testConfig))
 	if err != nil {
@@ -77,6 +119,250 @@ func TestRandomPeer(t *testing.T) {
 // 	}
 // }
 
+func TestRemoveHandler(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	first := member.AddHandler(HandlerFunc(func(m *protocol.Message) error { return nil }))
+	member.AddHandler(HandlerFunc(func(m *protocol.Message) error { return nil }))
+
+	if len(member.handlers) != 2 {
+		t.Errorf("expected 2 handlers, found %d", len(member.handlers))
+	}
+
+	if !first.Remove() {
+		t.Errorf("expected the handler to be removed")
+	}
+
+	if first.Remove() {
+		t.Errorf("expected a second remove to report the handler was already removed")
+	}
+
+	if len(member.handlers) != 1 {
+		t.Errorf("expected 1 handler, found %d", len(member.handlers))
+	}
+}
+
+func TestClose(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	member.Close()
+	member.Close()
+
+	select {
+	case <-member.Done():
+	default:
+		t.Errorf("expected Done to be closed")
+	}
+
+	if err := member.Err(); err != nil {
+		t.Errorf("expected no error after Close, found %v", err)
+	}
+
+	if err := member.Start(context.Background()); err == nil {
+		t.Errorf("expected Start to fail on a closed member")
+	}
+
+	if err := member.Send([]byte("hello")); err == nil {
+		t.Errorf("expected Send to fail on a closed member")
+	}
+}
+
+func TestRemovePeer(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err := member.dial("ipc:///tmp/ipc1.ipc"); err != nil {
+		t.Error(err)
+	}
+
+	if err := member.RemovePeer("IPC:///tmp/ipc1.ipc"); err != nil {
+		t.Error(err)
+	}
+
+	if _, ok := member.dialers["ipc:///tmp/ipc1.ipc"]; ok {
+		t.Errorf("expected the dialer of the removed peer to be closed")
+	}
+
+	for _, peer := range member.Members() {
+		if peer.Uri == "ipc:///tmp/ipc1.ipc" {
+			t.Errorf("expected the peer to be removed")
+		}
+	}
+
+	if err := member.RemovePeer("ipc:///tmp/ipc1.ipc"); err == nil {
+		t.Errorf("expected removing an unknown peer to fail")
+	}
+}
+
+func TestReap(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	member.peers[0].setState(LeftState, time.Now())
+	member.peers[1].setState(FaultyState, time.Now())
+
+	member.reap(time.Now())
+	if len(member.Members()) != 4 {
+		t.Errorf("expected tombstones to be kept within the timeout, found %d members", len(member.Members()))
+	}
+
+	// gossip about a left member must not bring it back
+	intro := member.peers[0]
+	intro.Id = "left"
+	member.peers[0].Id = "left"
+	if err := member.updatePeer(&intro, false); err != nil {
+		t.Error(err)
+	}
+
+	if member.peers[0].State() != LeftState {
+		t.Errorf("expected gossip to not resurrect a left member")
+	}
+
+	member.reap(time.Now().Add(member.config.TombstoneTimeout + time.Minute))
+	if len(member.Members()) != 2 {
+		t.Errorf("expected tombstones to be reaped after the timeout, found %d members", len(member.Members()))
+	}
+}
+
+// fixedClock always reads the same time
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestStateChangeClock(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	member, err := NewWithConfig(&BusyConfig{
+		Uri:   "ipc:///tmp/ipc0.ipc",
+		Peers: []string{"ipc:///tmp/ipc1.ipc"},
+	}, WithClock(fixedClock(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	member.portEvent(mangos.PortActionRemove, "ipc:///tmp/ipc1.ipc")
+
+	if peer := member.Members()[0]; !peer.Changed().Equal(now) {
+		t.Errorf("expected the state change to be stamped by the member clock, found %s", peer.Changed())
+	}
+}
+
+func TestControlWhileHandlersBusy(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	// no workers, so the handler loop blocks on the second message
+	var dropped uint64
+	member.queue = newDispatchQueue(1, OverflowBlock, &dropped)
+
+	go member.handlerLoop()
+	go member.controlLoop()
+
+	for i := 0; i < 2; i++ {
+		member.incomingMessages <- protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "peer")
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	intro := &Introduction{Key: member.conf().SharedKey, Id: "other", Uri: "ipc:///tmp/other.ipc"}
+	if err := gob.NewEncoder(buffer).Encode(intro); err != nil {
+		t.Fatal(err)
+	}
+
+	hello := member.hellomsg()
+	hello.Header.SourceId = "other"
+	if _, err := hello.Write(buffer.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	member.controlMessages <- hello
+
+	deadline := time.Now().Add(time.Second)
+	for member.peerById("other") == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the introduction to be handled while the handlers are busy")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReconnectFailed(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	uri := member.peers[0].Uri
+	member.peers[0].setState(FaultyState, time.Now())
+
+	member.reconnectFailed()
+	if member.peers[0].State() != FaultyState {
+		t.Errorf("expected a faulty peer to stay faulty until it is connected")
+	}
+
+	member.portEvent(mangos.PortActionAdd, uri)
+
+	member.lock.Lock()
+	peer := member.peers[0]
+	member.lock.Unlock()
+
+	if peer.State() != HealthyState || !peer.connected {
+		t.Errorf("expected a connected peer to be healthy")
+	}
+}
+
+func TestJoinUnreachableSeed(t *testing.T) {
+	conf := &BusyConfig{
+		Uri:              "ipc:///tmp/ipc0.ipc",
+		Peers:            []string{"ipc:///tmp/ipc1.ipc"},
+		SharedKey:        "default_shared_key",
+		RedialAttempts:   2,
+		MaxRedialBackoff: 10 * time.Millisecond,
+	}
+
+	member, err := NewWithConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	member.listening = true
+
+	joined := make(chan error, 1)
+	go func() {
+		_, err := member.Join(context.Background(), "unreachable://seed")
+		joined <- err
+	}()
+
+	select {
+	case err := <-joined:
+		if err == nil {
+			t.Errorf("expected joining an unreachable seed to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected join to give up on an unreachable seed")
+	}
+}
+
 func TestSend(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
@@ -124,3 +410,165 @@ func TestSend(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestReload(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	conf := *member.config
+	conf.Peers = []string{"ipc:///tmp/ipc1.ipc", "ipc:///tmp/ipc5.ipc"}
+	conf.SwimInterval = 2 * time.Minute
+	conf.SharedKey = "new_shared_key"
+
+	if err := member.Reload(&conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if member.conf().SwimInterval != 2*time.Minute {
+		t.Errorf("expected the new swim interval, got %s", member.conf().SwimInterval)
+	}
+
+	if member.Introduction().Key != "new_shared_key" {
+		t.Errorf("expected the new shared key to be used")
+	}
+
+	uris := make(map[string]bool)
+	for _, p := range member.Members() {
+		uris[p.Uri] = true
+	}
+
+	if len(uris) != 2 || !uris["ipc:///tmp/ipc1.ipc"] || !uris["ipc:///tmp/ipc5.ipc"] {
+		t.Errorf("expected ipc1 and ipc5 as peers, got %v", uris)
+	}
+
+	conf.Uri = "ipc:///tmp/ipc9.ipc"
+	if err := member.Reload(&conf); err == nil || !strings.Contains(err.Error(), "uri") {
+		t.Errorf("expected a uri change to be rejected, got: %v", err)
+	}
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	peers := [][]string{
+		{"ipc:///tmp/ipc1.ipc", "ipc:///tmp/ipc5.ipc"},
+		{"ipc:///tmp/ipc6.ipc"},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		conf := *member.conf()
+		conf.Peers = peers[i%2]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := member.Reload(&conf); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the members must match whichever configuration was applied last
+	want := make(map[string]bool)
+	for _, p := range member.conf().Peers {
+		want[p] = true
+	}
+
+	members := member.Members()
+	if len(members) != len(want) {
+		t.Errorf("expected the peers of the last reload %v, got %d members", member.conf().Peers, len(members))
+	}
+
+	for _, p := range members {
+		if !want[p.Uri] {
+			t.Errorf("expected only the peers of the last reload %v, found %s", member.conf().Peers, p.Uri)
+		}
+	}
+}
+
+func TestJoinDisconnectedSeed(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	member.listening = true
+
+	uri := member.peers[0].Uri
+	if err := member.dial(uri); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	member.portEvent(mangos.PortActionRemove, uri)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	reached, _ := member.Join(ctx, uri)
+	if reached != 0 {
+		t.Errorf("expected a seed with a running dialer but no connection not to count as reached, got %d", reached)
+	}
+
+	if member.peerByUri(uri).connected {
+		t.Errorf("expected only the port hook to mark a peer connected")
+	}
+}
+
+// blockingSocket is a socket whose sends never complete until unblocked
+type blockingSocket struct {
+	mangos.Socket
+	unblock chan struct{}
+}
+
+func (s blockingSocket) Send(b []byte) error {
+	<-s.unblock
+	return nil
+}
+
+func TestShutdownBlockedLeave(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock := blockingSocket{Socket: member.bussock, unblock: make(chan struct{})}
+	defer close(sock.unblock)
+
+	member.bussock = sock
+	member.listening = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- member.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected the deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected shutdown to give up on the leave at the deadline")
+	}
+
+	select {
+	case <-member.Done():
+	default:
+		t.Errorf("expected the member to be closed")
+	}
+}
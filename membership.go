@@ -0,0 +1,89 @@
+package busybody
+
+import (
+	"sync"
+	"time"
+)
+
+// the number of membership changes kept for MembershipEvents
+const membershipLogSize = 256
+
+// Kinds of membership change recorded by a member
+const (
+	MemberJoined    = "join"
+	MemberUpdated   = "update"
+	MemberRejoined  = "rejoin"
+	MemberSuspected = "suspect"
+	MemberRecovered = "alive"
+	MemberFailed    = "fail"
+	MemberLeft      = "leave"
+	MemberRemoved   = "remove"
+	MemberReaped    = "reap"
+)
+
+// MembershipEvent describes one change to the member list as seen locally
+type MembershipEvent struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	PeerId string    `json:"peer_id,omitempty"`
+	Peer   string    `json:"peer"`
+}
+
+// membershipLog is a ring buffer of the most recent membership changes
+type membershipLog struct {
+	lock   sync.Mutex
+	events []MembershipEvent
+	next   int
+	full   bool
+}
+
+func newMembershipLog(size int) *membershipLog {
+	return &membershipLog{events: make([]MembershipEvent, size)}
+}
+
+func (l *membershipLog) add(event MembershipEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the recorded events, oldest first
+func (l *membershipLog) list() []MembershipEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.full {
+		return append([]MembershipEvent(nil), l.events[:l.next]...)
+	}
+
+	out := make([]MembershipEvent, 0, len(l.events))
+	out = append(out, l.events[l.next:]...)
+	return append(out, l.events[:l.next]...)
+}
+
+// recordMembership remembers a change to the member list. It may be called
+// while holding the member lock.
+func (m *BusyMember) recordMembership(kind, id, uri string) {
+	event := MembershipEvent{Time: m.clock.Now(), Kind: kind, PeerId: id, Peer: uri}
+
+	m.membership.add(event)
+	m.publish(func() MonitorEntry { return MonitorEntry{Kind: MonitorKindMember, Member: &event} })
+
+	// causal ordering must not wait on a member which is gone, and must pick
+	// up the new counter of one which comes back after a restart
+	if m.causal != nil && kind != MemberUpdated && kind != MemberRecovered {
+		m.causal.forget(id)
+	}
+}
+
+// MembershipEvents returns the most recent changes to the member list,
+// oldest first
+func (m *BusyMember) MembershipEvents() []MembershipEvent {
+	return m.membership.list()
+}
@@ -5,44 +5,38 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
+	"time"
 
-	"github.com/zerklabs/auburn/log"
 	"github.com/zerklabs/busybody/protocol"
 )
 
-func (m *BusyMember) defaultMessage() *protocol.Message {
-	ct := protocol.NoCompression
-
-	if m.config.SnappyCompression {
-		ct = protocol.SnappyCompression
+// compressionType returns the body compression selected in the config
+func (m *BusyMember) compressionType() int {
+	switch {
+	case m.conf().SnappyCompression:
+		return protocol.SnappyCompression
+	case m.conf().DeflateCompression:
+		return protocol.DeflateCompression
+	case m.conf().ZlibCompression:
+		return protocol.ZlibCompression
 	}
 
-	if m.config.DeflateCompression {
-		ct = protocol.DeflateCompression
-	}
+	return protocol.NoCompression
+}
 
-	return protocol.NewMessage(protocol.StandardMessage, ct, m.id)
+func (m *BusyMember) defaultMessage() *protocol.Message {
+	return protocol.NewMessage(protocol.StandardMessage, m.compressionType(), m.id)
 }
 
 func (m *BusyMember) hellomsg() *protocol.Message {
-	ct := protocol.NoCompression
-
-	if m.config.SnappyCompression {
-		ct = protocol.SnappyCompression
-	}
-
-	if m.config.DeflateCompression {
-		ct = protocol.DeflateCompression
-	}
-
-	return protocol.NewMessage(protocol.HelloMessage, ct, m.id)
+	return protocol.NewMessage(protocol.HelloMessage, m.compressionType(), m.id)
 }
 
 func UnmarshalIntroduction(p *protocol.Message) (*Introduction, error) {
 	var intro Introduction
 
-	if p.MessageType() != protocol.HelloMessage {
-		return nil, fmt.Errorf("not a hello message")
+	if p.MessageType() != protocol.HelloMessage && p.MessageType() != protocol.LeaveMessage {
+		return nil, fmt.Errorf("not a hello or leave message")
 	}
 
 	body, err := p.Body()
@@ -53,12 +47,8 @@ func UnmarshalIntroduction(p *protocol.Message) (*Introduction, error) {
 	buffer := bytes.NewBuffer(body)
 	decoder := gob.NewDecoder(buffer)
 
-	if err := decoder.Decode(&intro); err != nil {
-		if err != io.EOF {
-			return nil, fmt.Errorf("error gob decoding introduction: %v", err)
-		} else {
-			log.Warnf("error during gob decoding: %v", err)
-		}
+	if err := decoder.Decode(&intro); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error gob decoding introduction: %v", err)
 	}
 
 	if intro.Id == "" {
@@ -69,10 +59,6 @@ func UnmarshalIntroduction(p *protocol.Message) (*Introduction, error) {
 		return nil, fmt.Errorf("invalid introduction message: uri missing")
 	}
 
-	if intro.Key == "" {
-		log.Warnf("shared key is empty")
-	}
-
 	return &intro, nil
 }
 
@@ -96,32 +82,86 @@ func (m *BusyMember) hello() error {
 	return m.send(msg)
 }
 
-// Send writes the given byte slice to the underlying protocol message
-func (m *BusyMember) Send(content []byte) error {
+// SendOption modifies an outgoing message before it is sent
+type SendOption func(msg *protocol.Message)
+
+// WithTTL makes receivers drop the message if it has not been dispatched
+// within ttl of being sent
+func WithTTL(ttl time.Duration) SendOption {
+	return func(msg *protocol.Message) {
+		msg.SetTTL(ttl)
+	}
+}
+
+// WithTopic publishes the message to a topic, passed to ContextHandlers in
+// the delivery envelope
+func WithTopic(topic string) SendOption {
+	return func(msg *protocol.Message) {
+		msg.Header.Topic = topic
+	}
+}
+
+// Send writes the given byte slice to the underlying protocol message. When
+// causal ordering is enabled the message is stamped with this members vector
+// clock so receivers can hold it back until its predecessors are delivered.
+func (m *BusyMember) Send(content []byte, opts ...SendOption) error {
 	msg := m.defaultMessage()
 
+	for _, opt := range opts {
+		opt(msg)
+	}
+
 	if _, err := msg.Write(content); err != nil {
 		return fmt.Errorf("error writing content to message: %v", err)
 	}
 
+	if m.causal != nil {
+		return m.causal.send(msg, func() error { return m.send(msg) })
+	}
+
 	return m.send(msg)
 }
 
+// send splits messages larger than the configured fragment size and writes
+// each frame to the bus
 func (m *BusyMember) send(msg *protocol.Message) error {
+	if msg.Header.BodyLen <= m.conf().MaxFragmentSize {
+		return m.sendFrame(msg)
+	}
+
+	fragments, err := msg.Fragment(m.conf().MaxFragmentSize)
+	if err != nil {
+		return fmt.Errorf("error fragmenting message: %v", err)
+	}
+
+	for _, frag := range fragments {
+		if err := m.sendFrame(frag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *BusyMember) sendFrame(msg *protocol.Message) error {
+	msg.Header.Version = m.conf().FormatVersion
+
 	sendbuf := bytes.NewBuffer(nil)
 	n, err := sendbuf.ReadFrom(msg)
 	if err != nil {
 		return err
 	}
 
-	if m.config.LogLevel >= log.DEBUG && msg.MessageType() == protocol.StandardMessage {
-		log.Debugf("sending %d bytes", n)
+	if msg.MessageType() == protocol.StandardMessage {
+		m.log.Debug("sent message", "type", msg.MessageType(), "bytes", n)
 	}
 
-	if err := m.bussock.Send(sendbuf.Bytes()); err != nil {
+	if err := m.enqueue(msg.MessageType(), sendbuf.Bytes()); err != nil {
 		return fmt.Errorf("error sending message: %v", err)
 	}
 
+	m.recordSent(msg)
+
 	return nil
 }
 
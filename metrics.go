@@ -0,0 +1,278 @@
+package busybody
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// Metrics receives the measurements taken by a member. Labels are given as
+// alternating key and value pairs, e.g. "type", "hello".
+type Metrics interface {
+	// Counter adds delta to a monotonically increasing counter
+	Counter(name string, delta uint64, labels ...string)
+	// Gauge sets a value which can go up and down
+	Gauge(name string, value float64, labels ...string)
+	// Observe records one sample, such as a duration in seconds
+	Observe(name string, value float64, labels ...string)
+}
+
+// Metric names reported by a member
+const (
+	MetricMessagesSent     = "busybody_messages_sent_total"
+	MetricMessagesReceived = "busybody_messages_received_total"
+	MetricBytesSent        = "busybody_body_bytes_sent_total"
+	MetricBytesReceived    = "busybody_body_bytes_received_total"
+	MetricDecodeErrors     = "busybody_decode_errors_total"
+	MetricUnauthorized     = "busybody_unauthorized_introductions_total"
+	MetricHandlerDuration  = "busybody_handler_duration_seconds"
+	MetricHandlerErrors    = "busybody_handler_errors_total"
+	MetricHelloDelay       = "busybody_hello_delay_seconds"
+	MetricSuspicions       = "busybody_suspicions_total"
+	MetricFailures         = "busybody_failures_total"
+	MetricMembers          = "busybody_members"
+	MetricReceiveDropped   = "busybody_receive_dropped_total"
+	MetricMessagesExpired  = "busybody_messages_expired_total"
+)
+
+// NopMetrics discards every measurement
+type NopMetrics struct{}
+
+func (NopMetrics) Counter(name string, delta uint64, labels ...string)  {}
+func (NopMetrics) Gauge(name string, value float64, labels ...string)   {}
+func (NopMetrics) Observe(name string, value float64, labels ...string) {}
+
+// ExpvarMetrics keeps measurements in expvar maps, so they show up under
+// /debug/vars, and serves them in the Prometheus text format. Observations
+// are kept as a count and a sum, like a Prometheus summary without quantiles.
+type ExpvarMetrics struct {
+	lock     sync.Mutex
+	counters *expvar.Map
+	gauges   *expvar.Map
+	sums     *expvar.Map
+	counts   *expvar.Map
+}
+
+var (
+	expvarLock    sync.Mutex
+	expvarMetrics = make(map[string]*ExpvarMetrics)
+)
+
+// NewExpvarMetrics returns the metrics published under name in expvar. Every
+// call with the same name returns the same metrics, so members in one process
+// share them. An empty name keeps the metrics out of expvar.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+
+	if e, ok := expvarMetrics[name]; ok && name != "" {
+		return e
+	}
+
+	e := &ExpvarMetrics{
+		counters: new(expvar.Map).Init(),
+		gauges:   new(expvar.Map).Init(),
+		sums:     new(expvar.Map).Init(),
+		counts:   new(expvar.Map).Init(),
+	}
+
+	if name != "" {
+		root := expvar.NewMap(name)
+		root.Set("counters", e.counters)
+		root.Set("gauges", e.gauges)
+		root.Set("observation_sums", e.sums)
+		root.Set("observation_counts", e.counts)
+		expvarMetrics[name] = e
+	}
+
+	return e
+}
+
+// metricKey formats a metric name and labels the way Prometheus does
+func metricKey(name string, labels []string) string {
+	if len(labels) < 2 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (e *ExpvarMetrics) Counter(name string, delta uint64, labels ...string) {
+	e.counters.Add(metricKey(name, labels), int64(delta))
+}
+
+func (e *ExpvarMetrics) Gauge(name string, value float64, labels ...string) {
+	f := new(expvar.Float)
+	f.Set(value)
+	e.gauges.Set(metricKey(name, labels), f)
+}
+
+func (e *ExpvarMetrics) Observe(name string, value float64, labels ...string) {
+	key := metricKey(name, labels)
+
+	// keep the sum and count of a sample consistent for scrapes
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.sums.AddFloat(key, value)
+	e.counts.Add(key, 1)
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format
+func (e *ExpvarMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	writeFamilies(w, e.counters, "counter", func(key string) {
+		fmt.Fprintf(w, "%s %s\n", key, e.counters.Get(key).String())
+	})
+
+	writeFamilies(w, e.gauges, "gauge", func(key string) {
+		fmt.Fprintf(w, "%s %s\n", key, e.gauges.Get(key).String())
+	})
+
+	writeFamilies(w, e.sums, "summary", func(key string) {
+		name, labels := splitKey(key)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, e.sums.Get(key).String())
+		fmt.Fprintf(w, "%s_count%s %s\n", name, labels, e.counts.Get(key).String())
+	})
+}
+
+// splitKey splits a metric key into its name and labels
+func splitKey(key string) (string, string) {
+	if i := strings.Index(key, "{"); i >= 0 {
+		return key[:i], key[i:]
+	}
+
+	return key, ""
+}
+
+// writeFamilies writes a type line for every metric name in vars, followed
+// by each of its series through write
+func writeFamilies(w http.ResponseWriter, vars *expvar.Map, kind string, write func(key string)) {
+	families := make(map[string][]string)
+
+	vars.Do(func(kv expvar.KeyValue) {
+		name, _ := splitKey(kv.Key)
+		families[name] = append(families[name], kv.Key)
+	})
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+
+		for _, key := range families[name] {
+			write(key)
+		}
+	}
+}
+
+// MetricsHandler serves the member's metrics in the Prometheus text format.
+// Metrics set with WithMetrics are only served if they implement
+// http.Handler themselves.
+func (m *BusyMember) MetricsHandler() http.Handler {
+	if h, ok := m.metrics.(http.Handler); ok {
+		return h
+	}
+
+	return http.NotFoundHandler()
+}
+
+// WithMetrics sends the member's measurements to metrics instead of the
+// shared expvar metrics published as "busybody"
+func WithMetrics(metrics Metrics) Option {
+	return func(m *BusyMember) {
+		if metrics != nil {
+			m.metrics = metrics
+		}
+	}
+}
+
+// stateName returns the label used for a member state
+func stateName(state int) string {
+	switch state {
+	case HealthyState:
+		return "healthy"
+	case SuspiciousState:
+		return "suspicious"
+	case FaultyState:
+		return "faulty"
+	case LeftState:
+		return "left"
+	}
+
+	return "unknown"
+}
+
+// recordSent counts a frame handed to the bus
+func (m *BusyMember) recordSent(msg *protocol.Message) {
+	t := protocol.MessageTypeName(msg.MessageType())
+
+	m.metrics.Counter(MetricMessagesSent, 1, "type", t)
+	m.metrics.Counter(MetricBytesSent, uint64(msg.Header.BodyLen), "type", t, "stage", "uncompressed")
+	m.metrics.Counter(MetricBytesSent, uint64(msg.Header.CompBodyLen), "type", t, "stage", "compressed")
+}
+
+// recordReceived counts a frame read from the bus
+func (m *BusyMember) recordReceived(msg *protocol.Message) {
+	t := protocol.MessageTypeName(msg.MessageType())
+
+	m.metrics.Counter(MetricMessagesReceived, 1, "type", t)
+	m.metrics.Counter(MetricBytesReceived, uint64(msg.Header.BodyLen), "type", t, "stage", "uncompressed")
+	m.metrics.Counter(MetricBytesReceived, uint64(msg.Header.CompBodyLen), "type", t, "stage", "compressed")
+}
+
+// recordHandler records how long a handler took and whether it failed
+func (m *BusyMember) recordHandler(start time.Time, err error) {
+	m.metrics.Observe(MetricHandlerDuration, time.Since(start).Seconds())
+
+	if err != nil {
+		m.metrics.Counter(MetricHandlerErrors, 1)
+	}
+}
+
+// recordHello records the delay between a peer sending an introduction and
+// it arriving here. There are no probe replies to time a round trip, so this
+// is one way and only as accurate as the clocks of both members.
+func (m *BusyMember) recordHello(msg *protocol.Message) {
+	sent := time.Unix(0, msg.Timestamp())
+
+	if delay := msg.Received().Sub(sent); delay >= 0 && !msg.Received().IsZero() {
+		m.metrics.Observe(MetricHelloDelay, delay.Seconds())
+	}
+}
+
+// recordMembers sets the member count gauges. Members in one process share
+// the default metrics and the id derived from the hostname, so the gauges are
+// labelled with the uri of the member to keep them from overwriting each
+// other.
+func (m *BusyMember) recordMembers() {
+	uri := m.conf().Uri
+	counts := map[int]int{HealthyState: 0, SuspiciousState: 0, FaultyState: 0, LeftState: 0}
+
+	for _, peer := range m.Members() {
+		counts[peer.state] += 1
+	}
+
+	for state, n := range counts {
+		m.metrics.Gauge(MetricMembers, float64(n), "uri", uri, "state", stateName(state))
+	}
+}
@@ -0,0 +1,97 @@
+package busybody
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpvarMetricsPrometheus(t *testing.T) {
+	metrics := NewExpvarMetrics("")
+	metrics.Counter(MetricMessagesSent, 2, "type", "hello")
+	metrics.Counter(MetricMessagesSent, 1, "type", "hello")
+	metrics.Gauge(MetricMembers, 4, "state", "healthy")
+	metrics.Observe(MetricHandlerDuration, 0.5)
+	metrics.Observe(MetricHandlerDuration, 1.5)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE busybody_messages_sent_total counter",
+		`busybody_messages_sent_total{type="hello"} 3`,
+		"# TYPE busybody_members gauge",
+		`busybody_members{state="healthy"} 4`,
+		"# TYPE busybody_handler_duration_seconds summary",
+		"busybody_handler_duration_seconds_sum 2",
+		"busybody_handler_duration_seconds_count 2",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMemberGauges(t *testing.T) {
+	metrics := NewExpvarMetrics("")
+
+	member, err := NewWithConfig(&BusyConfig{
+		Uri:   "ipc:///tmp/ipc0.ipc",
+		Peers: []string{"ipc:///tmp/ipc1.ipc", "ipc:///tmp/ipc2.ipc"},
+	}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	// a second member sharing the metrics must not overwrite the gauges
+	other, err := NewWithConfig(&BusyConfig{
+		Uri:   "ipc:///tmp/ipc3.ipc",
+		Peers: []string{"ipc:///tmp/ipc1.ipc"},
+	}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	member.recordMembers()
+	other.recordMembers()
+
+	rec := httptest.NewRecorder()
+	member.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	for uri, n := range map[string]int{"ipc:///tmp/ipc0.ipc": 2, "ipc:///tmp/ipc3.ipc": 1} {
+		series := fmt.Sprintf(`busybody_members{uri=%q,state="healthy"} %d`, uri, n)
+		if !strings.Contains(rec.Body.String(), series) {
+			t.Errorf("expected %s, got:\n%s", series, rec.Body.String())
+		}
+	}
+}
+
+func TestExpiredMetric(t *testing.T) {
+	metrics := NewExpvarMetrics("")
+
+	member, err := NewWithConfig(&BusyConfig{Uri: "ipc:///tmp/ipc0.ipc"}, WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	msg := member.defaultMessage()
+	msg.Header.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+	msg.SetTTL(time.Second)
+
+	member.deliver(&Envelope{Message: msg})
+
+	if member.Stats().ExpiredMessages != 1 {
+		t.Errorf("expected one expired message, got %d", member.Stats().ExpiredMessages)
+	}
+
+	if v := metrics.counters.Get(MetricMessagesExpired); v == nil || v.String() != "1" {
+		t.Errorf("expected the expired counter to be 1, got %v", v)
+	}
+}
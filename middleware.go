@@ -0,0 +1,98 @@
+package busybody
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"time"
+)
+
+// Middleware wraps a handler with additional behaviour
+type Middleware func(next ContextHandler) ContextHandler
+
+// chain wraps h so the first middleware is the outermost
+func chain(h ContextHandler, mw []Middleware) ContextHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+
+	return h
+}
+
+// Use registers middleware applied to every handler, outside of any
+// middleware registered with the handler itself
+func (m *BusyMember) Use(mw ...Middleware) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.middleware = append(m.middleware, mw...)
+}
+
+// WithMiddleware wraps a single handler with the given middleware
+func WithMiddleware(mw ...Middleware) HandlerOption {
+	return func(r *registration) {
+		r.middleware = append(r.middleware, mw...)
+	}
+}
+
+// Recovery turns a panic in the wrapped handler into an error
+func Recovery() Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, env *Envelope) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					stack := make([]byte, 4096)
+					stack = stack[:runtime.Stack(stack, false)]
+					err = fmt.Errorf("handler panic: %v\n%s", r, stack)
+				}
+			}()
+
+			return next.HandleMessageContext(ctx, env)
+		})
+	}
+}
+
+// Timing reports how long the wrapped handler took for each message
+func Timing(report func(env *Envelope, d time.Duration, err error)) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+			start := time.Now()
+			err := next.HandleMessageContext(ctx, env)
+			report(env, time.Since(start), err)
+
+			return err
+		})
+	}
+}
+
+// MaxPayloadSize rejects messages whose decompressed body is larger than n
+// bytes before they reach the wrapped handler. The length in the header is
+// not trusted: the body is decompressed up to one byte past the limit.
+func MaxPayloadSize(n int) Middleware {
+	return func(next ContextHandler) ContextHandler {
+		return ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+			if env.Message.Header.BodyLen > n {
+				return fmt.Errorf("payload of %d bytes from %s exceeds %d bytes", env.Message.Header.BodyLen, env.Message.Sender(), n)
+			}
+
+			r, err := env.Message.BodyReader()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			read, err := io.Copy(ioutil.Discard, io.LimitReader(r, int64(n)+1))
+			if err != nil {
+				return fmt.Errorf("error reading payload from %s: %v", env.Message.Sender(), err)
+			}
+
+			if read > int64(n) {
+				return fmt.Errorf("payload from %s exceeds %d bytes", env.Message.Sender(), n)
+			}
+
+			return next.HandleMessageContext(ctx, env)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package busybody
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+func TestRecovery(t *testing.T) {
+	h := chain(ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		panic("boom")
+	}), []Middleware{Recovery()})
+
+	if err := h.HandleMessageContext(context.Background(), &Envelope{}); err == nil {
+		t.Errorf("expected the panic to be returned as an error")
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	var took time.Duration
+
+	tag := func(name string) Middleware {
+		return func(next ContextHandler) ContextHandler {
+			return ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+				order = append(order, name)
+				return next.HandleMessageContext(ctx, env)
+			})
+		}
+	}
+
+	timing := Timing(func(env *Envelope, d time.Duration, err error) {
+		took = d
+	})
+
+	h := chain(ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		order = append(order, "handler")
+		time.Sleep(time.Millisecond)
+		return nil
+	}), []Middleware{tag("outer"), timing, tag("inner")})
+
+	if err := h.HandleMessageContext(context.Background(), &Envelope{}); err != nil {
+		t.Error(err)
+	}
+
+	if len(order) != 3 || order[0] != "outer" || order[1] != "inner" || order[2] != "handler" {
+		t.Errorf("unexpected middleware order: %v", order)
+	}
+
+	if took < time.Millisecond {
+		t.Errorf("expected timing to be reported, found %s", took)
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	msg := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+	msg.Write([]byte("Supercalifragilisticexpialidocious"))
+
+	h := chain(ContextHandlerFunc(func(ctx context.Context, env *Envelope) error {
+		return nil
+	}), []Middleware{MaxPayloadSize(10)})
+
+	if err := h.HandleMessageContext(context.Background(), &Envelope{Message: msg}); err == nil {
+		t.Errorf("expected the payload to be rejected")
+	}
+
+	// the length in the header is not trusted
+	msg.Header.BodyLen = 1
+	if err := h.HandleMessageContext(context.Background(), &Envelope{Message: msg}); err == nil {
+		t.Errorf("expected the payload with a forged length to be rejected")
+	}
+
+	small := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test")
+	small.Write([]byte("tiny"))
+	if err := h.HandleMessageContext(context.Background(), &Envelope{Message: small}); err != nil {
+		t.Errorf("expected a small payload to be accepted: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package busybody
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Kinds of entry sent to monitors
+const (
+	MonitorKindMember  = "member"
+	MonitorKindMessage = "message"
+	MonitorKindEvent   = "event"
+)
+
+// MonitorEntry is one thing a monitor saw happen: a membership change, a
+// message delivered to the handlers, or a user event
+type MonitorEntry struct {
+	Time    time.Time         `json:"time"`
+	Kind    string            `json:"kind"`
+	Member  *MembershipEvent  `json:"member,omitempty"`
+	Message *MonitorMessage   `json:"message,omitempty"`
+	Event   *MonitorUserEvent `json:"event,omitempty"`
+}
+
+// MonitorMessage summarises a delivered message
+type MonitorMessage struct {
+	Sender string `json:"sender"`
+	Topic  string `json:"topic,omitempty"`
+	Body   []byte `json:"body"`
+}
+
+// MonitorUserEvent summarises a delivered user event
+type MonitorUserEvent struct {
+	Name    string `json:"name"`
+	Origin  string `json:"origin"`
+	LTime   uint64 `json:"ltime"`
+	Payload []byte `json:"payload"`
+}
+
+// monitors fans entries out to every subscriber
+type monitors struct {
+	lock sync.Mutex
+	subs map[chan MonitorEntry]struct{}
+}
+
+// Monitor returns a channel receiving membership changes, delivered messages
+// and user events until ctx is done or the member is closed, when the channel
+// is closed. Entries are dropped if the channel's buffer of size is full.
+func (m *BusyMember) Monitor(ctx context.Context, size int) <-chan MonitorEntry {
+	ch := make(chan MonitorEntry, size)
+
+	m.monitors.lock.Lock()
+	if m.monitors.subs == nil {
+		m.monitors.subs = make(map[chan MonitorEntry]struct{})
+	}
+	m.monitors.subs[ch] = struct{}{}
+	m.monitors.lock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.StopChan:
+		}
+
+		m.monitors.lock.Lock()
+		delete(m.monitors.subs, ch)
+		close(ch)
+		m.monitors.lock.Unlock()
+	}()
+
+	return ch
+}
+
+// publish sends the entry built by entry to every monitor without blocking.
+// entry is only called if someone is listening.
+func (m *BusyMember) publish(entry func() MonitorEntry) {
+	m.monitors.lock.Lock()
+	listening := len(m.monitors.subs) > 0
+	m.monitors.lock.Unlock()
+
+	if !listening {
+		return
+	}
+
+	// building the entry may decompress a body, so keep it out of the lock
+	e := entry()
+	e.Time = m.clock.Now()
+
+	m.monitors.lock.Lock()
+	defer m.monitors.lock.Unlock()
+
+	for ch := range m.monitors.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
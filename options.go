@@ -0,0 +1,41 @@
+package busybody
+
+import (
+	"github.com/gdamore/mangos"
+)
+
+// Option configures a member created by NewWithConfig
+type Option func(m *BusyMember)
+
+// WithLogger sends the member's log output to l
+func WithLogger(l Logger) Option {
+	return func(m *BusyMember) {
+		if l != nil {
+			m.log = m.newLogger(l)
+		}
+	}
+}
+
+// WithTransport adds a mangos transport to the bus socket, in addition to
+// tcp and ipc
+func WithTransport(t mangos.Transport) Option {
+	return func(m *BusyMember) {
+		m.bussock.AddTransport(t)
+	}
+}
+
+// WithClock replaces the wall clock, mostly useful in tests
+func WithClock(c Clock) Option {
+	return func(m *BusyMember) {
+		if c != nil {
+			m.clock = c
+		}
+	}
+}
+
+// WithHandler registers h before the member is started
+func WithHandler(h Handler) Option {
+	return func(m *BusyMember) {
+		m.AddHandler(h)
+	}
+}
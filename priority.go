@@ -0,0 +1,100 @@
+package busybody
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+// queue depths of the control and application lanes, in messages
+const (
+	controlQueueSize     = 64
+	applicationQueueSize = 256
+)
+
+// outgoing is an encoded frame waiting for the send loop
+type outgoing struct {
+	frame []byte
+	errc  chan error
+}
+
+// controlMessage reports whether a message type is protocol-internal traffic
+// which must be served before application messages
+func controlMessage(msgtype int) bool {
+	switch msgtype {
+	case protocol.StandardMessage, protocol.UserEventMessage:
+		return false
+	}
+
+	return true
+}
+
+// enqueue hands a frame to the send loop on the lane matching msgtype and
+// waits for the result of the send
+func (m *BusyMember) enqueue(msgtype int, frame []byte) error {
+	lane := m.outgoingMessages
+	if controlMessage(msgtype) {
+		lane = m.controlOutgoing
+	}
+
+	out := &outgoing{frame: frame, errc: make(chan error, 1)}
+
+	select {
+	case lane <- out:
+	case <-m.StopChan:
+		return fmt.Errorf("member is closed")
+	}
+
+	select {
+	case err := <-out.errc:
+		return err
+	case <-m.StopChan:
+		return fmt.Errorf("member is closed")
+	}
+}
+
+// sendLoop writes queued frames to the bus, always draining the control
+// lane before taking an application frame
+func (m *BusyMember) sendLoop() {
+	for {
+		var out *outgoing
+
+		select {
+		case out = <-m.controlOutgoing:
+		default:
+			select {
+			case out = <-m.controlOutgoing:
+			case out = <-m.outgoingMessages:
+			case <-m.StopChan:
+				return
+			}
+		}
+
+		out.errc <- m.bussock.Send(out.frame)
+	}
+}
+
+// route queues a received message on the lane matching its type. A single
+// connection carries both lanes, so the receive loop cannot wait for room on
+// the application lane without holding up membership traffic behind it. A
+// full application lane therefore drops the incoming message, whatever the
+// overflow policy, which only governs the handler queue further along.
+func (m *BusyMember) route(msg *protocol.Message) {
+	if controlMessage(msg.MessageType()) {
+		select {
+		case m.controlMessages <- msg:
+		case <-m.StopChan:
+		}
+
+		return
+	}
+
+	select {
+	case m.incomingMessages <- msg:
+	default:
+		atomic.AddUint64(&m.stats.droppedReceived, 1)
+		m.metrics.Counter(MetricReceiveDropped, 1)
+		m.log.Debug("receive queue full, dropped message", "peer_id", msg.Sender(), "message_id", msg.MessageId())
+	}
+}
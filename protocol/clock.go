@@ -0,0 +1,51 @@
+package protocol
+
+// VectorClock maps a member id to the number of messages from that member
+// which causally precede a message (or which have been delivered locally)
+type VectorClock map[string]uint64
+
+// Copy returns a copy of the clock which is safe to attach to a message
+func (v VectorClock) Copy() VectorClock {
+	c := make(VectorClock, len(v))
+	for k, n := range v {
+		c[k] = n
+	}
+
+	return c
+}
+
+// Deliverable reports whether a message from sender stamped with clock c can
+// be delivered against the local clock v. The message must be the next one
+// from the sender and everything else it has seen must already be delivered.
+// Entries listed in ignore are skipped (e.g. members which have left).
+func (v VectorClock) Deliverable(sender string, c VectorClock, ignore map[string]bool) bool {
+	if c[sender] != v[sender]+1 {
+		return false
+	}
+
+	for k, n := range c {
+		if k == sender || ignore[k] {
+			continue
+		}
+
+		if n > v[k] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Prune removes every entry which is not in keep
+func (v VectorClock) Prune(keep map[string]bool) []string {
+	removed := make([]string, 0)
+
+	for k := range v {
+		if !keep[k] {
+			delete(v, k)
+			removed = append(removed, k)
+		}
+	}
+
+	return removed
+}
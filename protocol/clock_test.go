@@ -0,0 +1,36 @@
+package protocol
+
+import "testing"
+
+func TestVectorClockDeliverable(t *testing.T) {
+	local := VectorClock{"a": 1, "b": 0}
+
+	if !local.Deliverable("a", VectorClock{"a": 2}, nil) {
+		t.Errorf("expected next message from a to be deliverable")
+	}
+
+	if local.Deliverable("a", VectorClock{"a": 3}, nil) {
+		t.Errorf("expected a gap from a to be held back")
+	}
+
+	if local.Deliverable("b", VectorClock{"a": 2, "b": 1}, nil) {
+		t.Errorf("expected message from b depending on a:2 to be held back")
+	}
+
+	if !local.Deliverable("b", VectorClock{"a": 2, "b": 1}, map[string]bool{"a": true}) {
+		t.Errorf("expected ignored entries to be skipped")
+	}
+}
+
+func TestVectorClockPrune(t *testing.T) {
+	clock := VectorClock{"a": 1, "b": 2, "c": 3}
+
+	removed := clock.Prune(map[string]bool{"a": true, "c": true})
+	if len(removed) != 1 || removed[0] != "b" {
+		t.Errorf("expected b to be pruned, found %v", removed)
+	}
+
+	if _, ok := clock["b"]; ok {
+		t.Errorf("expected b to be removed from the clock")
+	}
+}
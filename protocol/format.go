@@ -1,6 +1,9 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	HelloMessage     int = 0
@@ -9,8 +12,20 @@ const (
 	PingReplyMessage int = 3
 	PingRelayMessage int = 4
 	StandardMessage  int = 5
+	UserEventMessage int = 6
+	LeaveMessage     int = 7
 )
 
+// FormatVersion is the newest version of the wire format understood by this
+// package. Version 1 frames carry the decompressed body, version 2 frames
+// carry the body as compressed by the sender.
+const FormatVersion = 2
+
+// DefaultFormatVersion is the version written unless the header asks for
+// another one. It stays at 1 so members which only understand version 1 can
+// read every frame during a rolling upgrade.
+const DefaultFormatVersion = 1
+
 const (
 	NoCompression      int = 0
 	SnappyCompression  int = 1
@@ -18,6 +33,46 @@ const (
 	ZlibCompression    int = 3
 )
 
+// MessageTypeName returns a short name for a message type, or "unknown"
+func MessageTypeName(t int) string {
+	switch t {
+	case HelloMessage:
+		return "hello"
+	case PingMessage:
+		return "ping"
+	case PingReqMessage:
+		return "ping_req"
+	case PingReplyMessage:
+		return "ping_reply"
+	case PingRelayMessage:
+		return "ping_relay"
+	case StandardMessage:
+		return "standard"
+	case UserEventMessage:
+		return "user_event"
+	case LeaveMessage:
+		return "leave"
+	}
+
+	return "unknown"
+}
+
+// CompressionName returns a short name for a compression type, or "unknown"
+func CompressionName(c int) string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case SnappyCompression:
+		return "snappy"
+	case DeflateCompression:
+		return "deflate"
+	case ZlibCompression:
+		return "zlib"
+	}
+
+	return "unknown"
+}
+
 //  0                   1                   2                   3
 //  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
 // +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
@@ -52,15 +107,17 @@ func Decode(msg []byte) (*Message, error) {
 	}
 
 	protocol := &Message{
-		Header: header,
-		buf:    make([]byte, 0),
-		off:    0,
+		Header:   header,
+		buf:      make([]byte, 0),
+		off:      0,
+		received: time.Now(),
 	}
 
 	// n also accounts for the NULSEP byte sequence
 	if len(msg) > n {
-		_, err := protocol.Write(msg[n:])
-		if err != nil {
+		if header.Version >= 2 {
+			protocol.buf = msg[n:]
+		} else if _, err := protocol.Write(msg[n:]); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,265 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// MaxFragmentCount is the most fragments a message may be split into. Larger
+// counts are rejected before anything is buffered for them.
+const MaxFragmentCount = 1 << 16
+
+// newMessageId returns a random id shared by the fragments of a message
+func newMessageId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return fmt.Sprintf("%x", b)
+}
+
+// MessageId returns the id shared by the fragments of a message
+func (m *Message) MessageId() string {
+	return m.Header.MessageId
+}
+
+// Fragmented reports whether the message is one fragment of a larger message
+func (m *Message) Fragmented() bool {
+	return m.Header.FragCount > 1
+}
+
+// Fragment splits the body of the message into messages carrying at most
+// size bytes of the uncompressed body each. A message which already fits is
+// returned as is.
+func (m *Message) Fragment(size int) ([]*Message, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid fragment size: %d", size)
+	}
+
+	body, err := m.decodebody()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) <= size {
+		return []*Message{m}, nil
+	}
+
+	count := (len(body) + size - 1) / size
+	if count > MaxFragmentCount {
+		return nil, fmt.Errorf("message of %d bytes needs %d fragments, more than the maximum of %d", len(body), count, MaxFragmentCount)
+	}
+
+	id := newMessageId()
+	fragments := make([]*Message, 0, count)
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(body) {
+			end = len(body)
+		}
+
+		frag := NewMessage(m.Header.MsgType, m.Header.CompressionType, m.Header.SourceId)
+		frag.Header.Version = m.Header.Version
+		frag.Header.Timestamp = m.Header.Timestamp
+		frag.Header.Deadline = m.Header.Deadline
+		frag.Header.Clock = m.Header.Clock
+		frag.Header.MessageId = id
+		frag.Header.Topic = m.Header.Topic
+		frag.Header.Codec = m.Header.Codec
+		frag.Header.TypeName = m.Header.TypeName
+		frag.Header.FragIndex = i
+		frag.Header.FragCount = count
+
+		if _, err := frag.Write(body[i*size : end]); err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, frag)
+	}
+
+	return fragments, nil
+}
+
+// partial is a message still waiting for some of its fragments
+type partial struct {
+	first    *Message
+	parts    [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// Reassembler collects fragments until every fragment of a message has
+// arrived. Incomplete messages are dropped after the timeout, or when
+// buffering them would exceed the memory limit.
+type Reassembler struct {
+	lock     sync.Mutex
+	timeout  time.Duration
+	limit    int
+	size     int
+	partials map[string]*partial
+	order    []string
+}
+
+func NewReassembler(timeout time.Duration, limit int) *Reassembler {
+	return &Reassembler{
+		timeout:  timeout,
+		limit:    limit,
+		partials: make(map[string]*partial),
+		order:    make([]string, 0),
+	}
+}
+
+// Add buffers a fragment and returns the reassembled message once every
+// fragment has been received, otherwise nil
+func (r *Reassembler) Add(frag *Message, now time.Time) (*Message, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.expire(now)
+
+	// every fragment carries at least one byte, so a count above the limit
+	// can never be reassembled
+	if frag.Header.FragCount > MaxFragmentCount || frag.Header.FragCount > r.limit {
+		return nil, fmt.Errorf("fragment count %d exceeds the maximum", frag.Header.FragCount)
+	}
+
+	if frag.Header.FragIndex < 0 || frag.Header.FragIndex >= frag.Header.FragCount {
+		return nil, fmt.Errorf("invalid fragment index %d of %d", frag.Header.FragIndex, frag.Header.FragCount)
+	}
+
+	body, err := r.readBody(frag)
+	if err != nil {
+		return nil, err
+	}
+
+	key := frag.Header.SourceId + "/" + frag.Header.MessageId
+
+	p, ok := r.partials[key]
+	if !ok {
+		p = &partial{
+			parts:   make([][]byte, frag.Header.FragCount),
+			started: now,
+		}
+		r.partials[key] = p
+		r.order = append(r.order, key)
+	}
+
+	if len(p.parts) != frag.Header.FragCount {
+		return nil, fmt.Errorf("fragment count mismatch for message %s", frag.Header.MessageId)
+	}
+
+	if p.parts[frag.Header.FragIndex] != nil {
+		return nil, nil
+	}
+
+	// make room by dropping the oldest incomplete messages
+	for r.size+len(body) > r.limit && len(r.order) > 0 {
+		r.drop(r.order[0])
+	}
+
+	if _, ok := r.partials[key]; !ok {
+		return nil, fmt.Errorf("message %s dropped, reassembly memory limit exceeded", frag.Header.MessageId)
+	}
+
+	if frag.Header.FragIndex == 0 {
+		p.first = frag
+	}
+
+	p.parts[frag.Header.FragIndex] = body
+	p.received += 1
+	p.size += len(body)
+	r.size += len(body)
+
+	if p.received < len(p.parts) {
+		return nil, nil
+	}
+
+	r.drop(key)
+
+	msg := NewMessage(p.first.Header.MsgType, p.first.Header.CompressionType, p.first.Header.SourceId)
+	msg.Header.Timestamp = p.first.Header.Timestamp
+	msg.Header.Deadline = p.first.Header.Deadline
+	msg.Header.Clock = p.first.Header.Clock
+	msg.Header.MessageId = p.first.Header.MessageId
+	msg.Header.Topic = p.first.Header.Topic
+	msg.Header.Codec = p.first.Header.Codec
+	msg.Header.TypeName = p.first.Header.TypeName
+	msg.received = now
+
+	whole := make([]byte, 0, p.size)
+	for _, part := range p.parts {
+		whole = append(whole, part...)
+	}
+
+	if _, err := msg.Write(whole); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// readBody decompresses the body of a fragment, giving up as soon as it grows
+// past the memory limit so an oversized fragment is never inflated in full.
+// Must hold the lock.
+func (r *Reassembler) readBody(frag *Message) ([]byte, error) {
+	br, err := frag.BodyReader()
+	if err != nil {
+		return nil, err
+	}
+	defer br.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(br, int64(r.limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading from compressed stream: %v", err)
+	}
+
+	if len(body) > r.limit {
+		return nil, fmt.Errorf("fragment exceeds the reassembly memory limit of %d bytes", r.limit)
+	}
+
+	if err := br.Close(); err != nil {
+		return nil, fmt.Errorf("error closing compressed stream: %v", err)
+	}
+
+	return body, nil
+}
+
+// Pending returns the number of bytes buffered for incomplete messages
+func (r *Reassembler) Pending() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.size
+}
+
+// expire drops incomplete messages older than the timeout. Must hold the lock.
+func (r *Reassembler) expire(now time.Time) {
+	for len(r.order) > 0 {
+		p := r.partials[r.order[0]]
+		if now.Sub(p.started) < r.timeout {
+			return
+		}
+
+		r.drop(r.order[0])
+	}
+}
+
+// drop forgets an incomplete message. Must hold the lock.
+func (r *Reassembler) drop(key string) {
+	if p, ok := r.partials[key]; ok {
+		r.size -= p.size
+		delete(r.partials, key)
+	}
+
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFragment(t *testing.T) {
+	msg := NewMessage(StandardMessage, DeflateCompression, testhostname())
+
+	content := strings.Repeat("Supercalifragilisticexpialidocious", 100)
+	msg.Write([]byte(content))
+
+	fragments, err := msg.Fragment(256)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(fragments) != 14 {
+		t.Errorf("expected 14 fragments, found %d", len(fragments))
+	}
+
+	r := NewReassembler(time.Minute, 1<<20)
+	now := time.Now()
+
+	var whole *Message
+
+	// deliver the fragments in reverse, over the wire
+	for i := len(fragments) - 1; i >= 0; i-- {
+		msgb := bytes.NewBuffer(nil)
+		msgb.ReadFrom(fragments[i])
+
+		decmsg, err := Decode(msgb.Bytes())
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !decmsg.Fragmented() {
+			t.Errorf("expected decoded message to be a fragment")
+		}
+
+		if whole, err = r.Add(decmsg, now); err != nil {
+			t.Error(err)
+		}
+
+		if i > 0 && whole != nil {
+			t.Errorf("message reassembled before every fragment was received")
+		}
+	}
+
+	if whole == nil {
+		t.Errorf("expected the message to be reassembled")
+		t.FailNow()
+	}
+
+	body, err := whole.Body()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(body) != content {
+		t.Errorf("incorrect body reassembled")
+	}
+
+	if r.Pending() != 0 {
+		t.Errorf("expected no pending bytes, found %d", r.Pending())
+	}
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+	msg := NewMessage(StandardMessage, NoCompression, testhostname())
+	msg.Write([]byte(strings.Repeat("a", 100)))
+
+	fragments, err := msg.Fragment(10)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	r := NewReassembler(time.Second, 1<<20)
+	now := time.Now()
+
+	r.Add(fragments[0], now)
+	if r.Pending() != 10 {
+		t.Errorf("expected 10 pending bytes, found %d", r.Pending())
+	}
+
+	r.Add(fragments[1], now.Add(2*time.Second))
+	if r.Pending() != 10 {
+		t.Errorf("expected the stale message to be dropped, found %d pending bytes", r.Pending())
+	}
+}
+
+func TestReassemblerLimit(t *testing.T) {
+	msg := NewMessage(StandardMessage, NoCompression, testhostname())
+	msg.Write([]byte(strings.Repeat("a", 100)))
+
+	fragments, err := msg.Fragment(10)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	r := NewReassembler(time.Minute, 25)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Add(fragments[i], now); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := r.Add(fragments[2], now); err == nil {
+		t.Errorf("expected the memory limit to be exceeded")
+	}
+
+	if r.Pending() != 0 {
+		t.Errorf("expected the message to be dropped, found %d pending bytes", r.Pending())
+	}
+}
+
+func TestReassemblerCompressedLimit(t *testing.T) {
+	frag := NewMessage(StandardMessage, DeflateCompression, testhostname())
+	frag.Header.MessageId = "inflated"
+	frag.Header.FragCount = 2
+	frag.Write(make([]byte, 1<<20))
+
+	r := NewReassembler(time.Minute, 1024)
+
+	if _, err := r.Add(frag, time.Now()); err == nil {
+		t.Errorf("expected a fragment inflating past the memory limit to be rejected")
+	}
+
+	if r.Pending() != 0 {
+		t.Errorf("expected nothing to be buffered, found %d bytes", r.Pending())
+	}
+}
+
+func TestReassemblerFragmentCount(t *testing.T) {
+	r := NewReassembler(time.Minute, 1<<20)
+
+	for _, count := range []int{MaxFragmentCount + 1, 1<<31 - 1} {
+		frag := NewMessage(StandardMessage, NoCompression, testhostname())
+		frag.Header.MessageId = "oversized"
+		frag.Header.FragCount = count
+		frag.Write([]byte("x"))
+
+		if _, err := r.Add(frag, time.Now()); err == nil {
+			t.Errorf("expected a fragment count of %d to be rejected", count)
+		}
+	}
+
+	if r.Pending() != 0 {
+		t.Errorf("expected nothing to be buffered, found %d bytes", r.Pending())
+	}
+
+	small := NewReassembler(time.Minute, 16)
+
+	frag := NewMessage(StandardMessage, NoCompression, testhostname())
+	frag.Header.MessageId = "overlimit"
+	frag.Header.FragCount = 17
+	frag.Write([]byte("x"))
+
+	if _, err := small.Add(frag, time.Now()); err == nil {
+		t.Errorf("expected a fragment count above the memory limit to be rejected")
+	}
+}
@@ -5,11 +5,10 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io"
+	"log"
 	"regexp"
 	"sync"
 	"time"
-
-	"github.com/zerklabs/auburn/log"
 )
 
 // const HeaderSize = 36
@@ -24,6 +23,14 @@ type MessageHeader struct {
 	Timestamp       int64
 	BodyLen         int
 	CompBodyLen     int
+	Clock           VectorClock
+	Deadline        int64
+	MessageId       string
+	FragIndex       int
+	FragCount       int
+	Topic           string
+	Codec           string
+	TypeName        string
 
 	off int // buf offset
 }
@@ -63,7 +70,7 @@ func buildMessageHeader(msgtype int, comptype int, id string) MessageHeader {
 	now := time.Now().UnixNano()
 
 	return MessageHeader{
-		Version:         1,
+		Version:         DefaultFormatVersion,
 		MsgType:         msgtype,
 		SourceId:        id,
 		Timestamp:       now,
@@ -75,7 +82,7 @@ func buildMessageHeader(msgtype int, comptype int, id string) MessageHeader {
 }
 
 func (h *MessageHeader) Print() {
-	log.Infof("%#v", h)
+	log.Printf("%#v", h)
 }
 
 func (h *MessageHeader) Length() int {
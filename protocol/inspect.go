@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+)
+
+// separator ends the encoded header of a frame
+var separator = []byte("NULSEP")
+
+// InspectError is a problem found in a frame, at the byte offset where it
+// was detected. Problems with a decoded field, whose position within the
+// encoded header is not known, span the whole header with Len set.
+type InspectError struct {
+	Offset int
+	Len    int // bytes the problem spans, 0 for a single offset
+	Reason string
+}
+
+func (e *InspectError) Error() string {
+	if e.Len > 0 {
+		return fmt.Sprintf("offset %d-%d: %s", e.Offset, e.Offset+e.Len, e.Reason)
+	}
+
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Reason)
+}
+
+func inspectErr(offset int, format string, v ...interface{}) *InspectError {
+	return &InspectError{Offset: offset, Reason: fmt.Sprintf(format, v...)}
+}
+
+// spanErr is an inspectErr spanning n bytes from offset
+func spanErr(offset, n int, format string, v ...interface{}) *InspectError {
+	err := inspectErr(offset, format, v...)
+	err.Len = n
+
+	return err
+}
+
+// countingReader counts the bytes read through it. It reads a byte at a time
+// when asked, so decompressors do not read ahead of what they consumed.
+type countingReader struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n += 1
+	}
+
+	return b, err
+}
+
+// Inspection is a frame taken apart field by field
+type Inspection struct {
+	Header      MessageHeader
+	HeaderLen   int    // bytes of encoded header, before the separator
+	BodyOffset  int    // offset of the body, after the separator
+	Type        string // name of the message type
+	Compression string // name of the compression type
+	Timestamp   time.Time
+	Deadline    time.Time // zero if the message never expires
+	RawBody     []byte    // body as found in the frame
+	Body        []byte    // decompressed body
+	Message     *Message  // the decoded message, once the frame is valid
+}
+
+// Inspect decodes a captured frame and checks it more strictly than Decode.
+// Problems are returned as an *InspectError naming the offset at which they
+// were found, along with whatever could be decoded up to that point.
+func Inspect(frame []byte) (*Inspection, error) {
+	insp := &Inspection{}
+
+	if len(frame) == 0 {
+		return insp, inspectErr(0, "empty frame")
+	}
+
+	sep := bytes.Index(frame, separator)
+	if sep < 0 {
+		return insp, inspectErr(len(frame), "no header separator in %d bytes", len(frame))
+	}
+
+	if sep == 0 {
+		return insp, inspectErr(0, "empty header")
+	}
+
+	insp.HeaderLen = sep
+	insp.BodyOffset = sep + len(separator)
+
+	r := bytes.NewReader(frame[:sep])
+	if err := gob.NewDecoder(r).Decode(&insp.Header); err != nil {
+		return insp, inspectErr(sep-r.Len(), "error decoding header: %v", err)
+	}
+
+	if r.Len() > 0 {
+		return insp, inspectErr(sep-r.Len(), "%d unexpected bytes after the header", r.Len())
+	}
+
+	h := &insp.Header
+	insp.Type = MessageTypeName(h.MsgType)
+	insp.Compression = CompressionName(h.CompressionType)
+	insp.Timestamp = time.Unix(0, h.Timestamp)
+
+	if h.Deadline != 0 {
+		insp.Deadline = time.Unix(0, h.Deadline)
+	}
+
+	if h.Version < 1 || h.Version > FormatVersion {
+		return insp, spanErr(0, insp.HeaderLen, "unsupported format version %d", h.Version)
+	}
+
+	if insp.Type == "unknown" {
+		return insp, spanErr(0, insp.HeaderLen, "unknown message type %d", h.MsgType)
+	}
+
+	if insp.Compression == "unknown" {
+		return insp, spanErr(0, insp.HeaderLen, "unknown compression type %d", h.CompressionType)
+	}
+
+	if h.FragCount > 1 && (h.FragIndex < 0 || h.FragIndex >= h.FragCount) {
+		return insp, spanErr(0, insp.HeaderLen, "fragment index %d out of range for %d fragments", h.FragIndex, h.FragCount)
+	}
+
+	insp.RawBody = frame[insp.BodyOffset:]
+
+	// version 1 frames carry the body decompressed
+	if h.Version < 2 {
+		insp.Body = insp.RawBody
+
+		if len(insp.Body) != h.BodyLen {
+			return insp, inspectErr(lengthOffset(insp.BodyOffset, len(insp.Body), h.BodyLen), "body is %d bytes, header says %d", len(insp.Body), h.BodyLen)
+		}
+	} else {
+		if len(insp.RawBody) != h.CompBodyLen {
+			return insp, inspectErr(lengthOffset(insp.BodyOffset, len(insp.RawBody), h.CompBodyLen), "compressed body is %d bytes, header says %d", len(insp.RawBody), h.CompBodyLen)
+		}
+
+		cr := &countingReader{r: bytes.NewReader(insp.RawBody)}
+
+		dr, err := newDecompressor(cr, h.CompressionType)
+		if err != nil {
+			return insp, inspectErr(insp.BodyOffset+cr.n, "%v", err)
+		}
+
+		body := bytes.NewBuffer(nil)
+		if _, err := body.ReadFrom(dr); err != nil {
+			return insp, inspectErr(insp.BodyOffset+cr.n, "error decompressing %s body: %v", insp.Compression, err)
+		}
+		dr.Close()
+
+		insp.Body = body.Bytes()
+
+		if len(insp.Body) != h.BodyLen {
+			return insp, spanErr(insp.BodyOffset, len(insp.RawBody), "body decompresses to %d bytes, header says %d", len(insp.Body), h.BodyLen)
+		}
+	}
+
+	msg, err := Decode(frame)
+	if err != nil {
+		return insp, spanErr(0, insp.HeaderLen, "%v", err)
+	}
+
+	insp.Message = msg
+
+	return insp, nil
+}
+
+// lengthOffset returns where a body of have bytes stops matching a header
+// expecting want: the end of the frame if it is short, or the first extra
+// byte if it is long
+func lengthOffset(start, have, want int) int {
+	if have < want {
+		return start + have
+	}
+
+	return start + want
+}
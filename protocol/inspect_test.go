@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func testFrame(t *testing.T, comptype int, body []byte) []byte {
+	msg := NewMessage(StandardMessage, comptype, testhostname())
+	msg.Header.Version = FormatVersion
+
+	if _, err := msg.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	frame, err := ioutil.ReadAll(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return frame
+}
+
+func TestInspect(t *testing.T) {
+	body := bytes.Repeat([]byte("inspect me "), 20)
+	frame := testFrame(t, DeflateCompression, body)
+
+	insp, err := Inspect(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if insp.Type != "standard" || insp.Compression != "deflate" {
+		t.Errorf("expected a standard deflate message, got %s %s", insp.Type, insp.Compression)
+	}
+
+	if !bytes.Equal(insp.Body, body) {
+		t.Errorf("expected the decompressed body, got %q", insp.Body)
+	}
+
+	if insp.Message == nil || insp.Message.Sender() != testhostname() {
+		t.Errorf("expected the decoded message")
+	}
+}
+
+func TestInspectMessageWriter(t *testing.T) {
+	body := bytes.Repeat([]byte("inspect me "), 20)
+	buf := bytes.NewBuffer(nil)
+
+	w := NewMessageWriter(buf, StandardMessage, SnappyCompression, testhostname())
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	insp, err := Inspect(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(insp.Body, body) {
+		t.Errorf("expected the decompressed body, got %q", insp.Body)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	frame := testFrame(t, NoCompression, []byte("this is a message"))
+	sep := bytes.Index(frame, separator)
+
+	header := buildMessageHeader(StandardMessage, NoCompression, testhostname())
+	header.Version = FormatVersion + 1
+
+	future, err := header.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad checksum is only found after reading the whole body
+	zframe := testFrame(t, ZlibCompression, bytes.Repeat([]byte("inspect me "), 20))
+	zframe[len(zframe)-1] ^= 0xff
+
+	tests := []struct {
+		name   string
+		frame  []byte
+		offset int
+		len    int
+	}{
+		{"empty", []byte{}, 0, 0},
+		{"no separator", frame[:sep], sep, 0},
+		{"empty header", frame[sep:], 0, 0},
+		{"truncated body", frame[:len(frame)-4], len(frame) - 4, 0},
+		{"trailing bytes", append(append([]byte(nil), frame...), 'x', 'y'), len(frame), 0},
+		{"truncated header", append([]byte{0xff, 0xff, 0xff}, frame[sep:]...), 3, 0},
+		{"unsupported version", future, 0, len(future) - len(separator)},
+		{"corrupt body", zframe, len(zframe), 0},
+	}
+
+	for _, test := range tests {
+		_, err := Inspect(test.frame)
+
+		ierr, ok := err.(*InspectError)
+		if !ok {
+			t.Errorf("%s: expected an InspectError, got %v", test.name, err)
+			continue
+		}
+
+		if ierr.Offset != test.offset || ierr.Len != test.len {
+			t.Errorf("%s: expected offset %d spanning %d bytes, got %d spanning %d (%v)", test.name, test.offset, test.len, ierr.Offset, ierr.Len, ierr)
+		}
+	}
+}
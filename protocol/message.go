@@ -2,25 +2,26 @@ package protocol
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/zlib"
 	"fmt"
 	"io"
+	"log"
 	"sync"
-
-	"github.com/mreiferson/go-snappystream"
-	"github.com/zerklabs/auburn/log"
+	"time"
 )
 
 type Message struct {
 	lock   sync.Mutex
 	Header MessageHeader
 	buf    []byte
-	off    int // read offset
+	body   []byte // decompressed body, kept by Body
+	frame  []byte // encoded header and body, built by Read
+	off    int    // read offset
+
+	received time.Time
 }
 
 func (m *Message) Print() {
-	log.Infof("%#v", m)
+	log.Printf("%#v", m)
 }
 
 // Length will return the entire message length with the compressed body, including the header
@@ -65,75 +66,125 @@ func (m *Message) Sender() string {
 	return m.Header.SourceId
 }
 
-// Body returns the decompressed body as a byte slice
+// Topic returns the topic the message was published to, if any
+func (m *Message) Topic() string {
+	return m.Header.Topic
+}
+
+// Codec returns the name of the codec used to encode the body, if any
+func (m *Message) Codec() string {
+	return m.Header.Codec
+}
+
+// TypeName returns the name of the type encoded in the body, if any
+func (m *Message) TypeName() string {
+	return m.Header.TypeName
+}
+
+// Received returns the time the message was decoded by this host, or the
+// zero time for locally created messages
+func (m *Message) Received() time.Time {
+	return m.received
+}
+
+// SetTTL sets the message to expire ttl after it was created. A ttl of 0
+// clears the deadline.
+func (m *Message) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		m.Header.Deadline = 0
+		return
+	}
+
+	m.Header.Deadline = m.Header.Timestamp + int64(ttl)
+}
+
+// Deadline returns the unix timestamp after which the message should be
+// dropped (nanosecond format), or 0 if it never expires
+func (m *Message) Deadline() int64 {
+	return m.Header.Deadline
+}
+
+// Expired reports whether the message deadline has passed at now
+func (m *Message) Expired(now time.Time) bool {
+	return m.Header.Deadline != 0 && now.UnixNano() > m.Header.Deadline
+}
+
+// VectorClock returns the causal clock the message was stamped with, or nil
+// if the sender did not use causal ordering
+func (m *Message) VectorClock() VectorClock {
+	return m.Header.Clock
+}
+
+// Body returns the decompressed body as a byte slice. The body is only
+// decompressed on the first call, so the slice is shared and must not be
+// modified.
 func (m *Message) Body() ([]byte, error) {
-	return m.decodebody()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.body != nil {
+		return m.body, nil
+	}
+
+	body, err := m.decodebody()
+	if err != nil {
+		return body, err
+	}
+
+	m.body = body
+
+	return body, nil
 }
 
 // decodebody returns the decompressed body as a byte slice. It will
 // check the compression type by the header value
 func (m *Message) decodebody() ([]byte, error) {
-	buf := bytes.NewBuffer(m.buf)
 	rawbuf := bytes.NewBuffer(nil)
 
-	switch m.Header.CompressionType {
-	case NoCompression:
-		rawbuf.ReadFrom(buf)
-
-		break
-	case DeflateCompression:
-		r := flate.NewReader(buf)
-		if _, nerr := rawbuf.ReadFrom(r); nerr != nil {
-			return rawbuf.Bytes(), fmt.Errorf("error reading from flate stream: %v", nerr)
-		}
-
-		if nerr := r.Close(); nerr != nil {
-			return rawbuf.Bytes(), fmt.Errorf("error closing flate stream: %v", nerr)
-		}
-	case ZlibCompression:
-		gz, nerr := zlib.NewReader(buf)
-		if nerr != nil {
-			return rawbuf.Bytes(), nerr
-		}
+	r, err := m.BodyReader()
+	if err != nil {
+		return rawbuf.Bytes(), err
+	}
 
-		if _, nerr := rawbuf.ReadFrom(gz); nerr != nil {
-			return rawbuf.Bytes(), fmt.Errorf("error reading from zlib stream: %v", nerr)
-		}
+	if _, err := rawbuf.ReadFrom(r); err != nil {
+		return rawbuf.Bytes(), fmt.Errorf("error reading from compressed stream: %v", err)
+	}
 
-		if nerr := gz.Close(); nerr != nil {
-			return rawbuf.Bytes(), fmt.Errorf("error closing zlib stream: %v", nerr)
-		}
-	case SnappyCompression:
-		w := snappystream.NewReader(buf, false)
-		if _, nerr := rawbuf.ReadFrom(w); nerr != nil {
-			return rawbuf.Bytes(), fmt.Errorf("error reading from snappystream: %v", nerr)
-		}
+	if err := r.Close(); err != nil {
+		return rawbuf.Bytes(), fmt.Errorf("error closing compressed stream: %v", err)
 	}
 
 	return rawbuf.Bytes(), nil
 }
 
+// Read writes the encoded header followed by the body. The body is written
+// compressed when the header asks for format version 2. The frame is built on
+// the first call so large messages can be read in several calls.
 func (m *Message) Read(p []byte) (n int, err error) {
-	rawbuf := bytes.NewBuffer(nil)
-	if _, err := rawbuf.ReadFrom(&m.Header); err != nil {
-		return 0, err
-	}
+	if m.frame == nil {
+		header, err := m.Header.encode()
+		if err != nil {
+			return 0, err
+		}
 
-	bodybytes, err := m.decodebody()
-	if err != nil {
-		return 0, err
-	}
+		bodybytes := m.buf
+		if m.Header.Version < 2 {
+			if bodybytes, err = m.decodebody(); err != nil {
+				return 0, err
+			}
+		}
 
-	rawbuf.Write(bodybytes)
+		m.frame = append(header, bodybytes...)
+	}
 
-	if m.off >= rawbuf.Len() {
+	if m.off >= len(m.frame) {
 		if len(p) == 0 {
 			return
 		}
 		return 0, io.EOF
 	}
 
-	n = copy(p, rawbuf.Bytes()[m.off:])
+	n = copy(p, m.frame[m.off:])
 	m.off += n
 
 	return
@@ -149,66 +200,29 @@ func (m *Message) encode(rawbuf *bytes.Buffer) (int64, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	var written int64
 	buf := bytes.NewBuffer(nil)
 
 	// set the body length to the uncompressed input length
 	m.Header.BodyLen = rawbuf.Len()
 
-	switch m.Header.CompressionType {
-	case NoCompression:
-		buf.ReadFrom(rawbuf)
-		written += int64(buf.Len())
-	case DeflateCompression:
-		w, err := flate.NewWriter(buf, flate.BestCompression)
-		if err != nil {
-			return 0, err
-		}
-
-		nn, err := rawbuf.WriteTo(w)
-		written += nn
-		if err != nil {
-			return written, fmt.Errorf("error writing to flate stream: %v", err)
-		}
-
-		if err := w.Flush(); err != nil {
-			return written, fmt.Errorf("error flushing flate stream: %v", err)
-		}
-
-		if err := w.Close(); err != nil {
-			return written, fmt.Errorf("error closing flate stream: %v", err)
-		}
-	case ZlibCompression:
-		gz, err := zlib.NewWriterLevel(buf, flate.BestCompression)
-		if err != nil {
-			return 0, err
-		}
-
-		nn, err := rawbuf.WriteTo(gz)
-		written += nn
-		if err != nil {
-			return written, fmt.Errorf("error writing to zlib stream: %v", err)
-		}
-
-		if err := gz.Flush(); err != nil {
-			return written, fmt.Errorf("error flushing zlib stream: %v", err)
-		}
+	w, err := newCompressor(buf, m.Header.CompressionType)
+	if err != nil {
+		return 0, err
+	}
 
-		if err := gz.Close(); err != nil {
-			return written, fmt.Errorf("error closing zlib stream: %v", err)
-		}
-	case SnappyCompression:
-		w := snappystream.NewWriter(buf)
+	written, err := rawbuf.WriteTo(w)
+	if err != nil {
+		return written, fmt.Errorf("error writing to compressed stream: %v", err)
+	}
 
-		nn, err := rawbuf.WriteTo(w)
-		written += nn
-		if err != nil {
-			return written, fmt.Errorf("error writing to snappystream: %v", err)
-		}
+	if err := w.Close(); err != nil {
+		return written, fmt.Errorf("error closing compressed stream: %v", err)
 	}
 
 	m.buf = buf.Bytes()
+	m.body = nil
 	m.Header.CompBodyLen = len(m.buf)
+	m.frame = nil
 
 	return written, nil
 }
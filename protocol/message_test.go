@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hash/crc32"
 	"testing"
+	"time"
 )
 
 func testhostname() string {
@@ -269,3 +270,55 @@ func TestDecode_Snappy(t *testing.T) {
 		t.Errorf("incorrect body decoded")
 	}
 }
+
+func TestExpired(t *testing.T) {
+	msg := NewMessage(StandardMessage, NoCompression, testhostname())
+
+	if msg.Expired(time.Now().Add(time.Hour)) {
+		t.Errorf("expected a message without a ttl to never expire")
+	}
+
+	msg.SetTTL(time.Minute)
+
+	msgb := bytes.NewBuffer(nil)
+	msgb.ReadFrom(msg)
+
+	decmsg, err := Decode(msgb.Bytes())
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if decmsg.Deadline() != msg.Deadline() {
+		t.Errorf("decoding deadline header failed, found %d, expected %d", decmsg.Deadline(), msg.Deadline())
+	}
+
+	if decmsg.Expired(time.Now()) {
+		t.Errorf("expected message to not be expired yet")
+	}
+
+	if !decmsg.Expired(time.Now().Add(2 * time.Minute)) {
+		t.Errorf("expected message to be expired after its ttl")
+	}
+}
+
+func TestBodyDecodedOnce(t *testing.T) {
+	msg := NewMessage(StandardMessage, DeflateCompression, testhostname())
+	msg.Write([]byte("first"))
+
+	first, err := msg.Body()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := msg.Body()
+	if &first[0] != &again[0] {
+		t.Errorf("expected the decoded body to be reused")
+	}
+
+	msg.Write([]byte("second"))
+
+	if body, _ := msg.Body(); string(body) != "second" {
+		t.Errorf("expected the body to be decoded again after a write, found %q", body)
+	}
+}
@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mreiferson/go-snappystream"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newCompressor returns a writer which compresses everything written to it
+// into w. Close must be called to flush the stream.
+func newCompressor(w io.Writer, comptype int) (io.WriteCloser, error) {
+	switch comptype {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case DeflateCompression:
+		return flate.NewWriter(w, flate.BestCompression)
+	case ZlibCompression:
+		return zlib.NewWriterLevel(w, flate.BestCompression)
+	case SnappyCompression:
+		return nopWriteCloser{snappystream.NewWriter(w)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown compression type: %d", comptype)
+}
+
+// newDecompressor returns a reader which decompresses r as it is read
+func newDecompressor(r io.Reader, comptype int) (io.ReadCloser, error) {
+	switch comptype {
+	case NoCompression:
+		return ioutil.NopCloser(r), nil
+	case DeflateCompression:
+		return flate.NewReader(r), nil
+	case ZlibCompression:
+		return zlib.NewReader(r)
+	case SnappyCompression:
+		return ioutil.NopCloser(snappystream.NewReader(r, false)), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression type: %d", comptype)
+}
+
+// BodyReader returns a reader which decompresses the body as it is read,
+// without holding the decompressed body in memory
+func (m *Message) BodyReader() (io.ReadCloser, error) {
+	return newDecompressor(bytes.NewReader(m.buf), m.Header.CompressionType)
+}
+
+// MessageWriter writes a message to an underlying writer, compressing the
+// body incrementally as it is written. The compressed body is buffered until
+// Close, which writes the header with the body lengths filled in followed by
+// the body, so the header may be modified until then.
+//
+// The frame is written in the format version set in the header. Version 1
+// frames carry the decompressed body, so it is buffered as well and the
+// compressed body only counts towards CompBodyLen.
+type MessageWriter struct {
+	Header *MessageHeader
+	w      io.Writer
+	buf    bytes.Buffer
+	raw    bytes.Buffer
+	body   io.WriteCloser
+	n      int
+	closed bool
+}
+
+func NewMessageWriter(w io.Writer, msgtype int, comptype int, id string) *MessageWriter {
+	header := buildMessageHeader(msgtype, comptype, id)
+
+	return &MessageWriter{
+		Header: &header,
+		w:      w,
+	}
+}
+
+// start opens the compressed body stream
+func (mw *MessageWriter) start() error {
+	if mw.body != nil {
+		return nil
+	}
+
+	var err error
+	if mw.body, err = newCompressor(&mw.buf, mw.Header.CompressionType); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Write compresses p into the body of the message
+func (mw *MessageWriter) Write(p []byte) (int, error) {
+	if mw.closed {
+		return 0, fmt.Errorf("message writer is closed")
+	}
+
+	if err := mw.start(); err != nil {
+		return 0, err
+	}
+
+	n, err := mw.body.Write(p)
+	mw.n += n
+
+	if mw.Header.Version < 2 {
+		mw.raw.Write(p[:n])
+	}
+
+	return n, err
+}
+
+// Close flushes the compressed body and writes the message. It does not close
+// the underlying writer.
+func (mw *MessageWriter) Close() error {
+	if mw.closed {
+		return nil
+	}
+
+	if err := mw.start(); err != nil {
+		return err
+	}
+
+	mw.closed = true
+
+	if err := mw.body.Close(); err != nil {
+		return err
+	}
+
+	mw.Header.BodyLen = mw.n
+	mw.Header.CompBodyLen = mw.buf.Len()
+
+	header, err := mw.Header.encode()
+	if err != nil {
+		return err
+	}
+
+	if _, err := mw.w.Write(header); err != nil {
+		return err
+	}
+
+	body := mw.buf.Bytes()
+	if mw.Header.Version < 2 {
+		body = mw.raw.Bytes()
+	}
+
+	_, err = mw.w.Write(body)
+
+	return err
+}
@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMessageWriter(t *testing.T) {
+	content := strings.Repeat("Supercalifragilisticexpialidocious", 100)
+
+	for _, version := range []int{1, FormatVersion} {
+		for _, ct := range []int{NoCompression, DeflateCompression, ZlibCompression, SnappyCompression} {
+			buf := bytes.NewBuffer(nil)
+
+			w := NewMessageWriter(buf, StandardMessage, ct, testhostname())
+			w.Header.Version = version
+			for i := 0; i < 100; i++ {
+				if _, err := w.Write([]byte("Supercalifragilisticexpialidocious")); err != nil {
+					t.Error(err)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Error(err)
+			}
+
+			decmsg, err := Decode(buf.Bytes())
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if decmsg.Version() != version {
+				t.Errorf("expected format version %d, found %d", version, decmsg.Version())
+			}
+
+			if decmsg.CompressionType() != ct {
+				t.Errorf("decoding compression type failed")
+			}
+
+			if decmsg.Header.BodyLen != len(content) || decmsg.Header.CompBodyLen != len(decmsg.buf) {
+				t.Errorf("expected body lengths %d and %d, found %d and %d", len(content), len(decmsg.buf), decmsg.Header.BodyLen, decmsg.Header.CompBodyLen)
+			}
+
+			r, err := decmsg.BodyReader()
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			body, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if string(body) != content {
+				t.Errorf("incorrect body streamed for compression type %d", ct)
+			}
+		}
+	}
+}
+
+func TestDecode_Version1(t *testing.T) {
+	content := "Supercalifragilisticexpialidocious"
+
+	header := buildMessageHeader(StandardMessage, DeflateCompression, testhostname())
+	header.Version = 1
+
+	b, err := header.encode()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// version 1 frames carry the decompressed body
+	decmsg, err := Decode(append(b, []byte(content)...))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	body, err := decmsg.Body()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(body) != content {
+		t.Errorf("incorrect body decoded, found: %s, expected: %s", string(body), content)
+	}
+}
@@ -0,0 +1,152 @@
+package busybody
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow policies applied when a dispatch queue is full. With block the
+// handler loop waits for room, which holds up application messages only;
+// once the receive queue in front of it is full as well, incoming messages
+// are dropped and counted in Stats.DroppedReceived, since the connection also
+// carries membership traffic which must never wait on handlers.
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop_oldest"
+	OverflowDropNewest = "drop_newest"
+)
+
+func validOverflowPolicy(policy string) error {
+	switch policy {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+		return nil
+	}
+
+	return fmt.Errorf("unknown overflow policy: %s", policy)
+}
+
+// dispatchQueue is a bounded queue of deliveries waiting for a worker
+type dispatchQueue struct {
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []*Envelope
+	size     int
+	policy   string
+	closed   bool
+	dropped  *uint64
+}
+
+func newDispatchQueue(size int, policy string, dropped *uint64) *dispatchQueue {
+	q := &dispatchQueue{
+		items:   make([]*Envelope, 0, size),
+		size:    size,
+		policy:  policy,
+		dropped: dropped,
+	}
+
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+
+	return q
+}
+
+// push adds a delivery to the queue, applying the overflow policy when the
+// queue is full. It returns false if the delivery was not queued.
+func (q *dispatchQueue) push(env *Envelope) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) >= q.size && q.policy == OverflowBlock && !q.closed {
+		q.notFull.Wait()
+	}
+
+	if q.closed {
+		return false
+	}
+
+	if len(q.items) >= q.size {
+		atomic.AddUint64(q.dropped, 1)
+
+		if q.policy == OverflowDropNewest {
+			return false
+		}
+
+		q.items = q.items[1:]
+	}
+
+	q.items = append(q.items, env)
+	q.notEmpty.Signal()
+
+	return true
+}
+
+// pop blocks until a delivery is available. It returns false once the queue
+// is closed and drained.
+func (q *dispatchQueue) pop() (*Envelope, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+
+	if len(q.items) == 0 {
+		return nil, false
+	}
+
+	env := q.items[0]
+	q.items = q.items[1:]
+	q.notFull.Signal()
+
+	return env, true
+}
+
+// len returns the number of queued deliveries
+func (q *dispatchQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.items)
+}
+
+// close wakes every waiting worker. Queued deliveries are still handed out.
+func (q *dispatchQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+// worker delivers queued messages until the member is closed
+func (m *BusyMember) worker() {
+	defer m.workers.Done()
+
+	for {
+		env, ok := m.queue.pop()
+		if !ok {
+			return
+		}
+
+		m.deliver(env)
+	}
+}
+
+// handlerWorker serves a handler registered with its own queue
+func (m *BusyMember) handlerWorker(r *registration) {
+	defer m.workers.Done()
+
+	for {
+		env, ok := r.queue.pop()
+		if !ok {
+			return
+		}
+
+		if err := m.handle(r, env); err != nil {
+			m.log.Error("error during HandleMessage", "peer_id", env.Message.Sender(), "topic", env.Topic, "error", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package busybody
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zerklabs/busybody/protocol"
+)
+
+func TestDispatchQueueDropOldest(t *testing.T) {
+	var dropped uint64
+
+	q := newDispatchQueue(2, OverflowDropOldest, &dropped)
+	for i := 0; i < 3; i++ {
+		if !q.push(&Envelope{Topic: string(rune('a' + i))}) {
+			t.Errorf("expected push %d to be queued", i)
+		}
+	}
+
+	if dropped != 1 {
+		t.Errorf("expected 1 dropped delivery, found %d", dropped)
+	}
+
+	env, _ := q.pop()
+	if env.Topic != "b" {
+		t.Errorf("expected the oldest delivery to be dropped, found %s", env.Topic)
+	}
+}
+
+func TestDispatchQueueDropNewest(t *testing.T) {
+	var dropped uint64
+
+	q := newDispatchQueue(2, OverflowDropNewest, &dropped)
+	q.push(&Envelope{Topic: "a"})
+	q.push(&Envelope{Topic: "b"})
+
+	if q.push(&Envelope{Topic: "c"}) {
+		t.Errorf("expected the newest delivery to be dropped")
+	}
+
+	if dropped != 1 || q.len() != 2 {
+		t.Errorf("expected 1 dropped and 2 queued, found %d and %d", dropped, q.len())
+	}
+}
+
+func TestDispatchQueueClose(t *testing.T) {
+	var dropped uint64
+
+	q := newDispatchQueue(1, OverflowBlock, &dropped)
+	q.push(&Envelope{Topic: "a"})
+	q.close()
+
+	if q.push(&Envelope{Topic: "b"}) {
+		t.Errorf("expected push to a closed queue to fail")
+	}
+
+	if _, ok := q.pop(); !ok {
+		t.Errorf("expected queued deliveries to be drained after close")
+	}
+
+	if _, ok := q.pop(); ok {
+		t.Errorf("expected pop to fail once the queue is drained")
+	}
+}
+
+func TestRouteFullApplicationLane(t *testing.T) {
+	member, err := New([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+
+	first := protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "first")
+	member.route(first)
+	for i := 1; i < cap(member.incomingMessages); i++ {
+		member.route(protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "test"))
+	}
+
+	routed := make(chan struct{})
+	go func() {
+		member.route(protocol.NewMessage(protocol.StandardMessage, protocol.NoCompression, "last"))
+		close(routed)
+	}()
+
+	select {
+	case <-routed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected route to not block on a full application lane")
+	}
+
+	if member.Stats().DroppedReceived != 1 {
+		t.Errorf("expected 1 dropped message, found %d", member.Stats().DroppedReceived)
+	}
+
+	if head := <-member.incomingMessages; head != first {
+		t.Errorf("expected the newest message to be dropped")
+	}
+
+	// control traffic still gets through
+	hello := protocol.NewMessage(protocol.HelloMessage, protocol.NoCompression, "peer")
+	member.route(hello)
+
+	if got := <-member.controlMessages; got != hello {
+		t.Errorf("expected the hello on the control lane")
+	}
+}
@@ -0,0 +1,229 @@
+package busybody
+
+import (
+	"time"
+
+	"github.com/gdamore/mangos"
+)
+
+// the first delay before redialing a peer whose connection was lost
+const redialBaseBackoff = time.Second
+
+// portHook is installed on the bus socket to follow pipes to our peers. It
+// must not block, so the work is done on another goroutine.
+func (m *BusyMember) portHook(action mangos.PortAction, p mangos.Port) bool {
+	go m.portEvent(action, p.Address())
+	return true
+}
+
+// portEvent updates the connection state of the peer at uri and starts a
+// connection supervisor when its pipe is lost
+func (m *BusyMember) portEvent(action mangos.PortAction, uri string) {
+	if m.stopped() {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.peers {
+		if m.peers[i].Uri != uri {
+			continue
+		}
+
+		switch action {
+		case mangos.PortActionAdd:
+			m.peers[i].connected = true
+
+			for _, ch := range m.connectWaiters[uri] {
+				close(ch)
+			}
+			delete(m.connectWaiters, uri)
+
+			if m.peers[i].state == LeftState || m.peers[i].state == HealthyState {
+				continue
+			}
+
+			if m.peers[i].state == FaultyState {
+				m.log.Info("reconnected to faulty peer", "peer_id", m.peers[i].Id, "peer", uri)
+			}
+
+			m.peers[i].setState(HealthyState, m.clock.Now())
+			m.recordMembership(MemberRecovered, m.peers[i].Id, uri)
+		case mangos.PortActionRemove:
+			m.peers[i].connected = false
+			if m.peers[i].state == LeftState {
+				continue
+			}
+
+			m.peers[i].setState(SuspiciousState, m.clock.Now())
+			m.metrics.Counter(MetricSuspicions, 1)
+			m.recordMembership(MemberSuspected, m.peers[i].Id, uri)
+
+			m.log.Warn("lost connection", "peer_id", m.peers[i].Id, "peer", uri)
+
+			if !m.redialing[uri] {
+				m.redialing[uri] = true
+				go m.superviseConnection(uri)
+			}
+		}
+	}
+}
+
+// superviseConnection waits for the dialer of a peer whose connection was
+// lost to bring it back. The dialer redials on its own, so the peer is given
+// as long as the configured attempts would take with capped exponential
+// backoff before it is marked as faulty.
+func (m *BusyMember) superviseConnection(uri string) {
+	defer func() {
+		m.lock.Lock()
+		delete(m.redialing, uri)
+		m.lock.Unlock()
+	}()
+
+	// peers which dialed us have no dialer here
+	if err := m.dial(uri); err != nil {
+		m.log.Debug("error redialing", "peer", uri, "error", err)
+	}
+
+	for attempt := 0; attempt < m.conf().RedialAttempts; attempt++ {
+		select {
+		case <-m.StopChan:
+			return
+		case <-time.After(backoff(attempt, redialBaseBackoff, m.conf().MaxRedialBackoff)):
+		}
+
+		peer := m.peerByUri(uri)
+		if peer == nil || peer.state == LeftState {
+			return
+		}
+
+		if peer.connected {
+			m.log.Info("reconnected", "peer", uri)
+			return
+		}
+	}
+
+	m.setPeerConnection(uri, false, FaultyState)
+
+	m.log.Warn("giving up on peer", "peer", uri, "attempts", m.conf().RedialAttempts)
+}
+
+// dial starts a dialer for uri unless one is already running. A dialer keeps
+// redialing with the reconnect backoff whenever its connection is lost, so
+// every peer gets exactly one. A nil error only means the dialer is running;
+// the peer is connected once the port hook says so, see waitConnected.
+func (m *BusyMember) dial(uri string) error {
+	m.dialLock.Lock()
+	defer m.dialLock.Unlock()
+
+	if _, ok := m.dialers[uri]; ok {
+		return nil
+	}
+
+	d, err := m.bussock.NewDialer(uri, map[string]interface{}{
+		mangos.OptionReconnectTime:    redialBaseBackoff,
+		mangos.OptionMaxReconnectTime: m.conf().MaxRedialBackoff,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := d.Dial(); err != nil {
+		d.Close()
+		return err
+	}
+
+	m.dialers[uri] = d
+
+	return nil
+}
+
+// closeDialer stops redialing uri
+func (m *BusyMember) closeDialer(uri string) {
+	m.dialLock.Lock()
+	defer m.dialLock.Unlock()
+
+	if d, ok := m.dialers[uri]; ok {
+		if err := d.Close(); err != nil {
+			m.log.Debug("error closing dialer", "peer", uri, "error", err)
+		}
+
+		delete(m.dialers, uri)
+	}
+}
+
+// peerByUri returns a copy of the introduction of the peer at uri
+func (m *BusyMember) peerByUri(uri string) *Introduction {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, peer := range m.peers {
+		if peer.Uri == uri {
+			intro := peer
+			return &intro
+		}
+	}
+
+	return nil
+}
+
+// setPeerConnection records the connection state of the peer at uri. Peers
+// which have left are never changed.
+func (m *BusyMember) setPeerConnection(uri string, connected bool, state int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.peers {
+		if m.peers[i].Uri == uri && m.peers[i].state != LeftState {
+			if state == FaultyState && m.peers[i].state != FaultyState {
+				m.metrics.Counter(MetricFailures, 1)
+				m.recordMembership(MemberFailed, m.peers[i].Id, uri)
+			}
+
+			if state == HealthyState && m.peers[i].state != HealthyState {
+				m.recordMembership(MemberRecovered, m.peers[i].Id, uri)
+			}
+
+			m.peers[i].connected = connected
+			m.peers[i].setState(state, m.clock.Now())
+		}
+	}
+}
+
+// reconnectFailed makes sure every faulty member which has not been reaped
+// yet is being dialed, so members heal after long partitions. A peer is only
+// marked healthy again once the port hook reports its connection.
+func (m *BusyMember) reconnectFailed() {
+	for _, peer := range m.Members() {
+		if peer.state != FaultyState {
+			continue
+		}
+
+		if err := m.dial(peer.Uri); err != nil {
+			m.log.Debug("error reconnecting", "peer", peer.Uri, "error", err)
+		}
+	}
+}
+
+// reap removes faulty and left members whose tombstone is older than the
+// configured timeout
+func (m *BusyMember) reap(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	peers := make([]Introduction, 0, len(m.peers))
+
+	for _, peer := range m.peers {
+		if (peer.state == FaultyState || peer.state == LeftState) && now.Sub(peer.changed) > m.conf().TombstoneTimeout {
+			m.log.Info("reaped peer", "peer_id", peer.Id, "peer", peer.Uri)
+			m.recordMembership(MemberReaped, peer.Id, peer.Uri)
+			m.closeDialer(peer.Uri)
+			continue
+		}
+
+		peers = append(peers, peer)
+	}
+
+	m.peers = peers
+}
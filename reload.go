@@ -0,0 +1,177 @@
+package busybody
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Reload applies a new configuration to a running member. Peers added to the
+// configuration are joined and removed ones are forgotten, the swim and
+// reconnect tickers pick up new intervals, and the log level, compression,
+// shared key, codec and timeouts take effect for the next message. Settings
+// which size the member's queues and buffers, and the uri it listens on,
+// cannot change without a restart and are rejected. Concurrent reloads are
+// applied one at a time.
+func (m *BusyMember) Reload(config *BusyConfig) error {
+	if config == nil {
+		return fmt.Errorf("configuration missing")
+	}
+
+	// peers are diffed against the previous configuration, so a reload must
+	// finish before the next one starts
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	conf := *config
+	conf.Peers = append([]string(nil), config.Peers...)
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	m.configLock.Lock()
+	old := m.config
+
+	if err := fixedSettings(old, &conf); err != nil {
+		m.configLock.Unlock()
+		return err
+	}
+
+	// the logger is chosen when the member is created
+	conf.Logger = old.Logger
+	m.config = &conf
+	m.configLock.Unlock()
+
+	if conf.SwimInterval != old.SwimInterval {
+		m.swimTicker.Reset(conf.SwimInterval)
+	}
+
+	if conf.ReconnectInterval != old.ReconnectInterval {
+		m.reconnectTicker.Reset(conf.ReconnectInterval)
+	}
+
+	added, removed := diffPeers(old.Peers, conf.Peers)
+
+	for _, peer := range removed {
+		if err := m.RemovePeer(peer); err != nil {
+			m.log.Debug("error removing peer", "peer", peer, "error", err)
+		}
+	}
+
+	m.lock.RLock()
+	listening := m.listening
+	m.lock.RUnlock()
+
+	for _, peer := range added {
+		if !listening {
+			if err := m.AddPeer(peer); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// connecting may take a while, so added peers are joined in the
+		// background
+		go m.joinSeed(m.ctx, peer)
+	}
+
+	m.log.Info("configuration reloaded", "added", len(added), "removed", len(removed))
+
+	// let the cluster know about a new shared key or peers straight away
+	if listening && !m.stopped() {
+		if err := m.hello(); err != nil {
+			m.log.Error("error sending introduction", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// fixedSettings returns an error naming the first setting which differs
+// between old and conf but cannot be changed on a running member
+func fixedSettings(old, conf *BusyConfig) error {
+	fixed := []struct {
+		key     string
+		changed bool
+	}{
+		{"uri", !strings.EqualFold(old.Uri, conf.Uri)},
+		{"causal_ordering", old.CausalOrdering != conf.CausalOrdering},
+		{"handler_workers", old.HandlerWorkers != conf.HandlerWorkers},
+		{"handler_queue_size", old.HandlerQueueSize != conf.HandlerQueueSize},
+		{"overflow_policy", old.OverflowPolicy != conf.OverflowPolicy},
+		{"event_buffer_size", old.EventBufferSize != conf.EventBufferSize},
+		{"event_coalesce_period", old.EventCoalescePeriod != conf.EventCoalescePeriod},
+		{"fragment_timeout", old.FragmentTimeout != conf.FragmentTimeout},
+		{"fragment_memory_limit", old.FragmentMemoryLimit != conf.FragmentMemoryLimit},
+	}
+
+	for _, f := range fixed {
+		if f.changed {
+			return fmt.Errorf("%s cannot be changed without restarting the member", f.key)
+		}
+	}
+
+	return nil
+}
+
+// diffPeers returns the peers only in next, and those only in prev
+func diffPeers(prev, next []string) (added, removed []string) {
+	seen := make(map[string]bool, len(prev))
+	for _, p := range prev {
+		seen[strings.ToLower(p)] = true
+	}
+
+	wanted := make(map[string]bool, len(next))
+	for _, p := range next {
+		p = strings.ToLower(p)
+		wanted[p] = true
+
+		if !seen[p] {
+			added = append(added, p)
+		}
+	}
+
+	for p := range seen {
+		if !wanted[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	return
+}
+
+// ReloadOnSignal calls load and applies the result with Reload every time one
+// of sigs is received, SIGHUP if none are given, until the member is closed.
+// Errors are logged and leave the running configuration untouched.
+func (m *BusyMember) ReloadOnSignal(load func() (*BusyConfig, error), sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-m.StopChan:
+				return
+			case <-ch:
+				conf, err := load()
+				if err != nil {
+					m.log.Error("error loading configuration", "error", err)
+					continue
+				}
+
+				if err := m.Reload(conf); err != nil {
+					m.log.Error("error reloading configuration", "error", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,30 @@
+//go:build go1.21
+
+package busybody
+
+import (
+	"log/slog"
+)
+
+// slogLogger passes log output on to a log/slog Logger
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger adapts l to the Logger interface. A nil l uses slog.Default().
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) Debug(msg string, keyvals ...interface{}) { s.l.Debug(msg, keyvals...) }
+func (s slogLogger) Info(msg string, keyvals ...interface{})  { s.l.Info(msg, keyvals...) }
+func (s slogLogger) Warn(msg string, keyvals ...interface{})  { s.l.Warn(msg, keyvals...) }
+func (s slogLogger) Error(msg string, keyvals ...interface{}) { s.l.Error(msg, keyvals...) }
+
+func (s slogLogger) With(keyvals ...interface{}) Logger {
+	return slogLogger{l: s.l.With(keyvals...)}
+}
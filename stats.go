@@ -0,0 +1,30 @@
+package busybody
+
+import "sync/atomic"
+
+// Stats is a snapshot of the counters kept by a member
+type Stats struct {
+	ExpiredMessages        uint64
+	DroppedMessages        uint64 // dropped by the handler queue's overflow policy
+	DroppedHandlerMessages uint64 // dropped by handlers with their own queue
+	DroppedReceived        uint64 // dropped because the receive queue was full
+	QueuedMessages         int
+}
+
+type stats struct {
+	expiredMessages        uint64
+	droppedMessages        uint64
+	droppedHandlerMessages uint64
+	droppedReceived        uint64
+}
+
+// Stats returns a snapshot of the members counters
+func (m *BusyMember) Stats() Stats {
+	return Stats{
+		ExpiredMessages:        atomic.LoadUint64(&m.stats.expiredMessages),
+		DroppedMessages:        atomic.LoadUint64(&m.stats.droppedMessages),
+		DroppedHandlerMessages: atomic.LoadUint64(&m.stats.droppedHandlerMessages),
+		DroppedReceived:        atomic.LoadUint64(&m.stats.droppedReceived),
+		QueuedMessages:         m.queue.len(),
+	}
+}